@@ -4,9 +4,23 @@ go 1.24.0
 
 toolchain go1.24.3
 
-require github.com/aliyun/aliyun-oss-go-sdk v3.0.2+incompatible
+require (
+	github.com/aliyun/aliyun-oss-go-sdk v3.0.2+incompatible
+	github.com/pkg/sftp v1.13.10
+	golang.org/x/crypto v0.41.0
+	golang.org/x/net v0.43.0
+)
 
 require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
+
+// github.com/pkg/sftp imports github.com/kr/fs only for Client.Walk, which the
+// sftp backend lists directories with. This 2013 revision has the same
+// fs.Walker API as v0.1.0, and pinning it keeps the module building from
+// offline module caches and proxies that only hold this revision. Drop the
+// replace once v0.1.0 can be fetched everywhere donk is built.
+replace github.com/kr/fs => github.com/kr/fs v0.0.0-20131111012553-2788f0dbd169
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	donksrc "donk/src"
 )
@@ -14,27 +17,59 @@ const (
 
 donk usage:
   donk init
-  donk cfg pull <name>
-  donk cfg push <name>
-  donk cfg init <name>
-  donk lib pull <name>
+  donk cfg pull <name> [--force | --prefer-local | --prefer-remote] [--dry-run] [--jobs <n>]
+  donk cfg push <name> [--force | --prefer-local | --prefer-remote] [--dry-run] [--jobs <n>]
+  donk cfg init <name> [--dry-run]
+  donk cfg log <name>
+  donk cfg checkout <name> <rev> [--push]
+  donk cfg status [name...] [--json]
+  donk cfg diff <name> [--rev <n>] [--stat] [--name-only]
+  donk cfg resolve <name>
+  donk lib pull <name> [--dry-run] [--jobs <n>]
+  donk lock status
+  donk lock break <name>
   donk help`
 
 	cfgHelpText = `USAGE:
-  donk cfg pull <name>
-  donk cfg push <name>
-  donk cfg init <name>
+  donk cfg pull <name> [--force | --prefer-local | --prefer-remote] [--dry-run] [--jobs <n>]
+  donk cfg push <name> [--force | --prefer-local | --prefer-remote] [--dry-run] [--jobs <n>]
+  donk cfg init <name> [--dry-run]
+  donk cfg log <name>
+  donk cfg checkout <name> <rev> [--push]
+  donk cfg status [name...] [--json]
+  donk cfg diff <name> [--rev <n>] [--stat] [--name-only]
+  donk cfg resolve <name>
 
 EXAMPLES:
   donk cfg push nvim
   donk cfg pull nvim
-  donk cfg init nvim`
+  donk cfg init nvim
+  donk cfg init nvim --dry-run
+  donk cfg log nvim
+  donk cfg checkout nvim 12 --push
+  donk cfg status
+  donk cfg status nvim --json
+  donk cfg diff nvim
+  donk cfg diff nvim --rev 12 --stat
+  donk cfg resolve nvim
+  donk cfg pull nvim --force
+  donk cfg push nvim --prefer-local
+  donk cfg pull nvim --jobs 16`
 
 	libHelpText = `USAGE:
-  donk lib pull <name>
+  donk lib pull <name> [--dry-run] [--jobs <n>]
 
-EXAMPLE:
-  donk lib pull zulu-jdk-8`
+EXAMPLES:
+  donk lib pull zulu-jdk-8
+  donk lib pull zulu-jdk-8 --dry-run`
+
+	lockHelpText = `USAGE:
+  donk lock status
+  donk lock break <name>
+
+EXAMPLES:
+  donk lock status
+  donk lock break nvim`
 
 	initHelpText = `USAGE:
   donk init`
@@ -61,6 +96,11 @@ func run(args []string) error {
 		return err
 	}
 
+	// Ctrl-C cancels in-flight transfers instead of killing the process, so
+	// temporary files are cleaned up.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	switch args[0] {
 	case "help", "-h", "--help":
 		fmt.Println(usageText)
@@ -80,6 +120,10 @@ func run(args []string) error {
 		if err != nil {
 			return err
 		}
+		context.Ctx = ctx
+		progress := donksrc.NewProgress(os.Stderr)
+		defer progress.Close()
+		context.Progress = progress
 		return donksrc.CreateCfgCmd(context).Run(args)
 	case "lib":
 		if isHelpArg(args, 1) {
@@ -90,7 +134,22 @@ func run(args []string) error {
 		if err != nil {
 			return err
 		}
+		context.Ctx = ctx
+		progress := donksrc.NewProgress(os.Stderr)
+		defer progress.Close()
+		context.Progress = progress
 		return donksrc.CreateLibCmd(context).Run(args)
+	case "lock":
+		if isHelpArg(args, 1) {
+			fmt.Println(lockHelpText)
+			return nil
+		}
+		context, err := initCmd.LoadContext()
+		if err != nil {
+			return err
+		}
+		context.Ctx = ctx
+		return donksrc.CreateLockCmd(context).Run(args)
 	default:
 		return fmt.Errorf("unknown command: %s\n\n%s", args[0], usageText)
 	}
@@ -0,0 +1,263 @@
+package src
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isArchivePath(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractArchive unpacks the archive at src into dst. The archive format is
+// picked from name, which is usually the remote path the archive came from.
+func extractArchive(src string, name string, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	extractor, err := newArchiveExtractor(dst)
+	if err != nil {
+		return err
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = extractor.extractZip(src)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		file, openErr := os.Open(src)
+		if openErr != nil {
+			return openErr
+		}
+		defer file.Close()
+		reader, gzipErr := gzip.NewReader(file)
+		if gzipErr != nil {
+			return fmt.Errorf("failed to read gzip archive: %w", gzipErr)
+		}
+		defer reader.Close()
+		err = extractor.extractTar(reader)
+	case strings.HasSuffix(name, ".tar"):
+		file, openErr := os.Open(src)
+		if openErr != nil {
+			return openErr
+		}
+		defer file.Close()
+		err = extractor.extractTar(file)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", name)
+	}
+	if err != nil {
+		return err
+	}
+	return extractor.checkLinks()
+}
+
+// archiveExtractor writes archive entries below dst. The directory of every
+// entry is resolved through the links extracted so far, so a chain of links
+// that each look harmless cannot lead a later entry out of dst.
+type archiveExtractor struct {
+	dst   string
+	links []string
+}
+
+func newArchiveExtractor(dst string) (*archiveExtractor, error) {
+	real, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveExtractor{dst: real}, nil
+}
+
+func (x *archiveExtractor) extractTar(reader io.Reader) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(header.Name)
+		case tar.TypeReg:
+			err = x.writeFile(header.Name, tarReader, os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			err = x.symlink(header.Name, header.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *archiveExtractor) extractZip(src string) error {
+	reader, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			if err := x.mkdir(file.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			return err
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			var linkname []byte
+			linkname, err = io.ReadAll(content)
+			if err == nil {
+				err = x.symlink(file.Name, string(linkname))
+			}
+		} else {
+			err = x.writeFile(file.Name, content, file.Mode().Perm())
+		}
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) mkdir(name string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(target, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func (x *archiveExtractor) writeFile(name string, content io.Reader, perm os.FileMode) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	return writeArchiveFile(target, content, perm)
+}
+
+func (x *archiveExtractor) symlink(name string, linkname string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(linkname) || !isWithinDir(x.dst, filepath.Join(filepath.Dir(target), linkname)) {
+		return fmt.Errorf("archive symbolic link points outside of the extraction directory: %s", name)
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.links = append(x.links, target)
+	return nil
+}
+
+// target returns the path an entry is written to, creating its missing parent
+// directories. Parents that are links must resolve inside the extraction
+// directory, and an entry never replaces a link.
+func (x *archiveExtractor) target(name string) (string, error) {
+	lexical, err := archiveTargetPath(x.dst, name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(x.dst, lexical)
+	if err != nil || rel == "." {
+		return x.dst, err
+	}
+	dir := x.dst
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		next := filepath.Join(dir, part)
+		info, err := os.Lstat(next)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if err := os.Mkdir(next, 0o755); err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		case info.Mode()&os.ModeSymlink != 0:
+			real, err := filepath.EvalSymlinks(next)
+			if err != nil || !isWithinDir(x.dst, real) {
+				return "", fmt.Errorf("archive entry points outside of the extraction directory through a symbolic link: %s", name)
+			}
+			next = real
+		case !info.IsDir():
+			return "", fmt.Errorf("archive entry is placed below a file: %s", name)
+		}
+		dir = next
+	}
+	target := filepath.Join(dir, filepath.Base(rel))
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("archive entry replaces a symbolic link: %s", name)
+	}
+	return target, nil
+}
+
+// checkLinks makes sure every extracted link resolves inside the extraction
+// directory once all entries are in place.
+func (x *archiveExtractor) checkLinks() error {
+	for _, link := range x.links {
+		real, err := filepath.EvalSymlinks(link)
+		if err != nil || !isWithinDir(x.dst, real) {
+			rel, _ := filepath.Rel(x.dst, link)
+			return fmt.Errorf("archive symbolic link points outside of the extraction directory or to a missing path: %s", filepath.ToSlash(rel))
+		}
+	}
+	return nil
+}
+
+func writeArchiveFile(target string, content io.Reader, perm os.FileMode) error {
+	if perm == 0 {
+		perm = 0o644
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func archiveTargetPath(dst string, name string) (string, error) {
+	target := filepath.Join(dst, filepath.FromSlash(name))
+	if !isWithinDir(dst, target) {
+		return "", fmt.Errorf("archive entry points outside of the extraction directory: %s", name)
+	}
+	return target, nil
+}
+
+func isWithinDir(base string, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,167 @@
+package src
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type archiveTestEntry struct {
+	name string
+	body string
+	link string
+	dir  bool
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveTestEntry
+		wantErr string
+		want    map[string]string
+	}{
+		{
+			name: "files, directories and links",
+			entries: []archiveTestEntry{
+				{name: "jdk/", dir: true},
+				{name: "jdk/bin/java", body: "java"},
+				{name: "jdk/release", body: "8"},
+				{name: "bin", link: "jdk/bin"},
+				{name: "jdk/current", link: "../jdk"},
+			},
+			want: map[string]string{"jdk/bin/java": "java", "jdk/release": "8", "bin/java": "java", "jdk/current/release": "8"},
+		},
+		{
+			name:    "path traversal",
+			entries: []archiveTestEntry{{name: "../evil", body: "evil"}},
+			wantErr: "archive entry points outside of the extraction directory: ../evil",
+		},
+		{
+			name:    "nested path traversal",
+			entries: []archiveTestEntry{{name: "jdk/../../evil", body: "evil"}},
+			wantErr: "archive entry points outside of the extraction directory",
+		},
+		{
+			name:    "link to a parent directory",
+			entries: []archiveTestEntry{{name: "up", link: ".."}, {name: "up/evil", body: "evil"}},
+			wantErr: "archive symbolic link points outside of the extraction directory: up",
+		},
+		{
+			name:    "absolute link",
+			entries: []archiveTestEntry{{name: "etc", link: "/etc"}},
+			wantErr: "archive symbolic link points outside of the extraction directory: etc",
+		},
+		{
+			name: "link chain escaping on write",
+			entries: []archiveTestEntry{
+				{name: "b", link: "."},
+				{name: "a", link: "b/.."},
+				{name: "a/evil", body: "evil"},
+			},
+			wantErr: "through a symbolic link: a/evil",
+		},
+		{
+			name:    "link chain escaping on its own",
+			entries: []archiveTestEntry{{name: "b", link: "."}, {name: "a", link: "b/.."}},
+			wantErr: "archive symbolic link points outside of the extraction directory or to a missing path: a",
+		},
+		{
+			name:    "file written through a link",
+			entries: []archiveTestEntry{{name: "release", body: "8"}, {name: "current", link: "release"}, {name: "current", body: "evil"}},
+			wantErr: "archive entry replaces a symbolic link: current",
+		},
+	}
+	for _, format := range []string{"lib.tar", "lib.tgz", "lib.zip"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				parent := t.TempDir()
+				src := filepath.Join(parent, format)
+				if err := os.WriteFile(src, buildTestArchive(t, format, tt.entries), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				dst := filepath.Join(parent, "lib")
+				err := extractArchive(src, format, dst)
+				checkTestErr(t, err, tt.wantErr)
+				if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+					t.Fatalf("extraction wrote outside of the extraction directory: %v", err)
+				}
+				for name, want := range tt.want {
+					got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+					if err != nil || string(got) != want {
+						t.Fatalf("%s = %q, %v, want %q", name, got, err, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func buildTestArchive(t *testing.T, format string, entries []archiveTestEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if strings.HasSuffix(format, ".zip") {
+		writer := zip.NewWriter(&buf)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			body := entry.body
+			switch {
+			case entry.dir:
+				header.SetMode(os.ModeDir | 0o755)
+			case entry.link != "":
+				header.SetMode(os.ModeSymlink | 0o777)
+				body = entry.link
+			default:
+				header.SetMode(0o644)
+			}
+			file, err := writer.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := file.Write([]byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var gzipWriter *gzip.Writer
+	var tarWriter *tar.Writer
+	if strings.HasSuffix(format, ".tgz") {
+		gzipWriter = gzip.NewWriter(&buf)
+		tarWriter = tar.NewWriter(gzipWriter)
+	} else {
+		tarWriter = tar.NewWriter(&buf)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0o755, 0
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(entry.body)); err != nil && header.Typeflag == tar.TypeReg {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
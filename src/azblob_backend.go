@@ -0,0 +1,589 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	azblobBackendProvider = "azblob"
+	azblobAPIVersion      = "2021-08-06"
+)
+
+// AzureBlobBackend talks to Azure Blob Storage or the Azurite emulator. The
+// account name is read from access_key, the shared key from secret_key and an
+// optional SAS token from token.
+type AzureBlobBackend struct {
+	cfg       OSSConfig
+	account   string
+	key       []byte
+	sasToken  string
+	container string
+	endpoint  *url.URL
+	client    *http.Client
+	opts      BackendOptions
+}
+
+type azblobListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				ContentLength int64  `xml:"Content-Length"`
+				ETag          string `xml:"Etag"`
+				LastModified  string `xml:"Last-Modified"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+type azblobBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+var errAzblobInvalidBlockList = errors.New("the staged blocks are no longer available")
+
+type azblobError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func init() {
+	RegisterBackend("azblob", azblobBackendProvider, func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewAzureBlobBackend(cfg, opts)
+	})
+}
+
+func NewAzureBlobBackend(cfg OSSConfig, opts BackendOptions) (*AzureBlobBackend, error) {
+	if cfg.AccessKey == "" {
+		return nil, errors.New("failed to initialize Azure Blob client because the account name is required in access_key")
+	}
+	if cfg.SecretKey == "" && cfg.Token == "" {
+		return nil, errors.New("failed to initialize Azure Blob client because either a shared key in secret_key or a SAS token in token is required")
+	}
+	if cfg.Bucket == "" || cfg.Bucket == "/" {
+		return nil, errors.New("failed to initialize Azure Blob client because container is required in bucket")
+	}
+
+	var key []byte
+	if cfg.Token == "" {
+		decoded, err := base64.StdEncoding.DecodeString(cfg.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Azure Blob client because the shared key is not valid base64: %w", err)
+		}
+		key = decoded
+	}
+
+	rawEndpoint := cfg.Endpoint
+	if rawEndpoint == "" {
+		rawEndpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.AccessKey)
+	}
+	if !strings.Contains(rawEndpoint, "://") {
+		rawEndpoint = "https://" + rawEndpoint
+	}
+	endpoint, err := url.Parse(rawEndpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("failed to initialize Azure Blob client because the endpoint is invalid: %s", cfg.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+
+	return &AzureBlobBackend{
+		cfg:       cfg,
+		account:   cfg.AccessKey,
+		key:       key,
+		sasToken:  strings.TrimPrefix(cfg.Token, "?"),
+		container: strings.Trim(cfg.Bucket, "/"),
+		endpoint:  endpoint,
+		client:    http.DefaultClient,
+		opts:      opts,
+	}, nil
+}
+
+func (a *AzureBlobBackend) Pull(src string, dst string) error {
+	return pullTree(a, a.opts, src, dst)
+}
+
+func (a *AzureBlobBackend) Push(src string, dst string) error {
+	return pushTree(a, a.opts, src, dst)
+}
+
+func (a *AzureBlobBackend) ReadObject(src string) ([]byte, error) {
+	name, err := a.parseUri(src)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("read failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+
+	resp, err := a.do(a.opts.ctx(), http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while opening Azure blob %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := a.checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("read failed while opening Azure blob %s: %w", name, err)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading content from Azure blob %s: %w", name, err)
+	}
+	return content, nil
+}
+
+func (a *AzureBlobBackend) WriteObject(dst string, content []byte) error {
+	name, err := a.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("write failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+	if err := a.put(a.opts.ctx(), name, content, nil); err != nil {
+		return fmt.Errorf("write failed while uploading Azure blob %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *AzureBlobBackend) List(prefix string) ([]ObjectInfo, error) {
+	name, err := a.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+
+	objects := make([]ObjectInfo, 0)
+	err = a.listBlobs(name, func(obj ObjectInfo) {
+		obj.Path = strings.TrimPrefix(obj.Path, name)
+		objects = append(objects, obj)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list failed while listing Azure blobs under prefix %s: %w", name, err)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (a *AzureBlobBackend) Delete(path string) error {
+	base, err := a.parseUri(path)
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		return errors.New("delete failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+
+	names := make([]string, 0)
+	err = a.listBlobs(base, func(obj ObjectInfo) {
+		if obj.Path == base || strings.HasPrefix(obj.Path, base+"/") {
+			names = append(names, obj.Path)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("delete failed while listing existing Azure blobs under prefix %s: %w", base, err)
+	}
+	for _, name := range names {
+		resp, err := a.do(a.opts.ctx(), http.MethodDelete, name, nil, nil, nil)
+		if err == nil {
+			if resp.StatusCode != http.StatusNotFound {
+				err = a.checkResponse(resp)
+			}
+			resp.Body.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("delete failed while deleting stale Azure blob %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (a *AzureBlobBackend) Stat(path string) (ObjectInfo, error) {
+	name, err := a.parseUri(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if name == "" {
+		return ObjectInfo{}, errors.New("stat failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+
+	resp, err := a.do(a.opts.ctx(), http.MethodHead, name, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading Azure blob properties %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	if err := a.checkResponse(resp); err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading Azure blob properties %s: %w", name, err)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Path:    name,
+		Size:    resp.ContentLength,
+		ETag:    strings.Trim(resp.Header.Get("ETag"), "\""),
+		ModTime: modTime,
+	}, nil
+}
+
+func (a *AzureBlobBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	name, err := a.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading Azure blob %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := a.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading Azure blob %s: %w", name, err)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("pull failed while downloading Azure blob %s: %w", name, err)
+	}
+	return file.Close()
+}
+
+func (a *AzureBlobBackend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	name, err := a.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodGet, name, nil, nil, rangeHeader(offset))
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading Azure blob %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := a.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading Azure blob %s: %w", name, err)
+	}
+	return copyRangeResponse(resp, offset, w)
+}
+
+func (a *AzureBlobBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	name, err := a.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("push failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() > multipartThreshold {
+		if err := a.uploadBlocks(ctx, src, dst, name, info); err != nil {
+			return fmt.Errorf("push failed while uploading Azure blob %s in blocks: %w", name, err)
+		}
+		return nil
+	}
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := a.put(ctx, name, content, nil); err != nil {
+		return fmt.Errorf("push failed while uploading Azure blob %s: %w", name, err)
+	}
+	return nil
+}
+
+// uploadBlocks stages a large file block by block and commits the block list
+// at the end. Staged blocks are recorded in a checkpoint, so an interrupted
+// upload resumes with the remaining blocks. The service drops uncommitted
+// blocks after a week, in which case the upload starts over once.
+func (a *AzureBlobBackend) uploadBlocks(ctx context.Context, src string, dst string, name string, info os.FileInfo) error {
+	cp := loadUploadCheckpoint(src, dst, info)
+	for attempt := 1; ; attempt++ {
+		err := a.stageBlocks(ctx, name, &cp)
+		if err == nil {
+			err = a.commitBlocks(ctx, name, cp)
+		}
+		if err == nil || !errors.Is(err, errAzblobInvalidBlockList) || attempt > 1 {
+			if err == nil {
+				cp.remove()
+			}
+			return err
+		}
+		cp.remove()
+		cp.Parts = map[int]string{}
+	}
+}
+
+func (a *AzureBlobBackend) stageBlocks(ctx context.Context, name string, cp *uploadCheckpoint) error {
+	// Block ids only need to be unique per blob, the checkpoint still needs
+	// an upload id to be picked up again.
+	cp.UploadID = name
+	for number := 1; number <= cp.partCount(); number++ {
+		if _, ok := cp.Parts[number]; ok {
+			continue
+		}
+		content, err := cp.readPart(number)
+		if err != nil {
+			return err
+		}
+		blockID := azblobBlockID(number)
+		query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+		resp, err := a.do(ctx, http.MethodPut, name, query, content, nil)
+		if err != nil {
+			return err
+		}
+		err = a.checkResponse(resp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		cp.Parts[number] = blockID
+		if err := cp.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AzureBlobBackend) commitBlocks(ctx context.Context, name string, cp uploadCheckpoint) error {
+	blockList := azblobBlockList{}
+	for number := 1; number <= cp.partCount(); number++ {
+		blockList.Latest = append(blockList.Latest, cp.Parts[number])
+	}
+	body, err := xml.Marshal(blockList)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodPut, name, url.Values{"comp": {"blocklist"}}, body, map[string]string{"Content-Type": "application/xml"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		return fmt.Errorf("%w: %w", errAzblobInvalidBlockList, a.checkResponse(resp))
+	}
+	return a.checkResponse(resp)
+}
+
+func azblobBlockID(number int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", number)))
+}
+
+func (a *AzureBlobBackend) WriteObjectIfMatch(dst string, content []byte, etag string) error {
+	name, err := a.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("write failed because the Azure Blob path is invalid and the blob name is missing")
+	}
+	headers := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		headers = map[string]string{"If-Match": `"` + etag + `"`}
+	}
+	if err := a.put(a.opts.ctx(), name, content, headers); err != nil {
+		return fmt.Errorf("write failed while uploading Azure blob %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *AzureBlobBackend) put(ctx context.Context, name string, content []byte, headers map[string]string) error {
+	requestHeaders := map[string]string{"x-ms-blob-type": "BlockBlob"}
+	for key, value := range headers {
+		requestHeaders[key] = value
+	}
+	resp, err := a.do(ctx, http.MethodPut, name, nil, content, requestHeaders)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		return errPreconditionFailed
+	}
+	return a.checkResponse(resp)
+}
+
+func (a *AzureBlobBackend) listBlobs(prefix string, visit func(obj ObjectInfo)) error {
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("prefix", prefix)
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := a.do(a.opts.ctx(), http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := a.checkResponse(resp); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		var result azblobListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse Azure Blob list response: %w", err)
+		}
+		for _, blob := range result.Blobs.Blob {
+			modTime, _ := http.ParseTime(blob.Properties.LastModified)
+			visit(ObjectInfo{
+				Path:    blob.Name,
+				Size:    blob.Properties.ContentLength,
+				ETag:    strings.Trim(blob.Properties.ETag, "\""),
+				ModTime: modTime,
+			})
+		}
+		if result.NextMarker == "" {
+			return nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (a *AzureBlobBackend) do(ctx context.Context, method string, name string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	target := *a.endpoint
+	target.Path = a.endpoint.Path + "/" + a.container
+	if name != "" {
+		target.Path += "/" + name
+	}
+	rawQuery := query.Encode()
+	if a.sasToken != "" {
+		rawQuery = strings.TrimPrefix(rawQuery+"&"+a.sasToken, "&")
+	}
+	target.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azblobAPIVersion)
+	if a.sasToken == "" {
+		a.sign(req, query)
+	}
+	return a.client.Do(req)
+}
+
+// sign applies the Shared Key authorization scheme to the request.
+func (a *AzureBlobBackend) sign(req *http.Request, query url.Values) {
+	h := hmac.New(sha256.New, a.key)
+	h.Write([]byte(a.stringToSign(req, query)))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, signature))
+}
+
+func (a *AzureBlobBackend) stringToSign(req *http.Request, query url.Values) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	headerNames := make([]string, 0)
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			headerNames = append(headerNames, lower)
+		}
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	var canonicalResource strings.Builder
+	canonicalResource.WriteString("/" + a.account + req.URL.EscapedPath())
+	queryNames := make([]string, 0, len(query))
+	for name := range query {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		canonicalResource.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"",
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + canonicalResource.String(),
+	}, "\n")
+}
+
+func (a *AzureBlobBackend) checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	content, _ := io.ReadAll(resp.Body)
+	var azErr azblobError
+	if xml.Unmarshal(content, &azErr) == nil && azErr.Code != "" {
+		return fmt.Errorf("Azure Blob request failed with status %d. Code: %s. Message: %s", resp.StatusCode, azErr.Code, azErr.Message)
+	}
+	return fmt.Errorf("Azure Blob request failed with status %d", resp.StatusCode)
+}
+
+func (a *AzureBlobBackend) parseUri(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "azblob://") {
+		return "", fmt.Errorf("invalid Azure Blob path because the scheme is not azblob://. Path: %s", raw)
+	}
+	withoutScheme := strings.TrimPrefix(raw, "azblob://")
+	parts := strings.SplitN(withoutScheme, "/", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		return "", fmt.Errorf("invalid Azure Blob path because the container segment is missing. Path: %s", raw)
+	}
+	if parts[0] != a.container {
+		return "", fmt.Errorf("invalid Azure Blob path because the container does not match the configured container. Path container: %s. Config container: %s", parts[0], a.container)
+	}
+	if len(parts) == 1 {
+		return "", nil
+	}
+	return strings.TrimPrefix(parts[1], "/"), nil
+}
@@ -0,0 +1,299 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAzureBlobSign checks the string to sign against the Get Container
+// Metadata example of the Shared Key documentation, and a Put Blob request
+// carrying a body and conditional headers.
+func TestAzureBlobSign(t *testing.T) {
+	key := []byte("azure-test-key")
+	a := &AzureBlobBackend{account: "myaccount", key: key}
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers map[string]string
+		body    string
+		want    string
+	}{
+		{
+			name:   "get container metadata",
+			method: http.MethodGet,
+			url:    "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=metadata&timeout=20",
+			headers: map[string]string{
+				"x-ms-date":    "Sun, 11 Oct 2009 21:49:13 GMT",
+				"x-ms-version": "2009-09-19",
+			},
+			want: "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:Sun, 11 Oct 2009 21:49:13 GMT\nx-ms-version:2009-09-19\n" +
+				"/myaccount/mycontainer\ncomp:metadata\nrestype:container\ntimeout:20",
+		},
+		{
+			name:   "put blob",
+			method: http.MethodPut,
+			url:    "https://myaccount.blob.core.windows.net/mycontainer/donk/a%20b.txt",
+			headers: map[string]string{
+				"Content-Type":   "text/plain",
+				"If-Match":       `"0x8CB171BA9E94B0B"`,
+				"x-ms-blob-type": "BlockBlob",
+				"x-ms-date":      "Sun, 11 Oct 2009 21:49:13 GMT",
+				"x-ms-version":   azblobAPIVersion,
+			},
+			body: "hello",
+			want: "PUT\n\n\n5\n\ntext/plain\n\n\n\"0x8CB171BA9E94B0B\"\n\n\n\n" +
+				"x-ms-blob-type:BlockBlob\nx-ms-date:Sun, 11 Oct 2009 21:49:13 GMT\nx-ms-version:" + azblobAPIVersion + "\n" +
+				"/myaccount/mycontainer/donk/a%20b.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := a.stringToSign(req, req.URL.Query()); got != tt.want {
+				t.Fatalf("stringToSign() =\n%q\nwant\n%q", got, tt.want)
+			}
+
+			a.sign(req, req.URL.Query())
+			h := hmac.New(sha256.New, key)
+			h.Write([]byte(tt.want))
+			want := "SharedKey myaccount:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Fatalf("Authorization = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAzureBlobBackend(t *testing.T) {
+	fake, a := newAzureBlobTestBackend(t)
+
+	// Listing follows the marker through more blobs than fit in one response,
+	// creating an existing blob is answered with 409 and a stale etag with 412.
+	testBackendContract(t, a, "azblob://team")
+
+	if fake.unsigned > 0 {
+		t.Fatalf("%d requests were not signed", fake.unsigned)
+	}
+}
+
+func TestAzureBlobBackendBlockUpload(t *testing.T) {
+	fake, a := newAzureBlobTestBackend(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), multipartPartSize/16+64)
+	src := filepath.Join(t.TempDir(), "large")
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A block staged by an earlier run that the service already dropped makes
+	// the commit fail, and the upload starts over.
+	cp := loadUploadCheckpoint(src, "azblob://team/large", info)
+	cp.UploadID = "large"
+	cp.Parts[1] = azblobBlockID(1)
+	if err := cp.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.uploadBlocks(context.Background(), src, "azblob://team/large", "large", info); err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.ReadObject("azblob://team/large")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("ReadObject() after the block upload returned %d bytes, %v, want %d", len(got), err, len(content))
+	}
+	if fake.blocks != 3 {
+		t.Fatalf("staged %d blocks, want 3", fake.blocks)
+	}
+	if _, err := os.Stat(cp.path); !os.IsNotExist(err) {
+		t.Fatalf("the upload checkpoint was kept after the upload finished: %v", err)
+	}
+}
+
+func newAzureBlobTestBackend(t *testing.T) (*azblobFake, *AzureBlobBackend) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	fake := newAzblobFake()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	a, err := NewAzureBlobBackend(OSSConfig{
+		Name:      "azblob",
+		Bucket:    "team",
+		Endpoint:  server.URL + "/devstoreaccount1",
+		AccessKey: "devstoreaccount1",
+		SecretKey: base64.StdEncoding.EncodeToString([]byte("secret")),
+	}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, a
+}
+
+// azblobFake is an emulator style Blob service keeping blobs in memory under
+// /devstoreaccount1/team. It answers at most two blobs per list page.
+type azblobFake struct {
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	staged   map[string]map[string][]byte
+	blocks   int
+	unsigned int
+}
+
+func newAzblobFake() *azblobFake {
+	return &azblobFake{blobs: map[string][]byte{}, staged: map[string]map[string][]byte{}}
+}
+
+func (f *azblobFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey devstoreaccount1:") || r.Header.Get("x-ms-version") == "" {
+		f.unsigned++
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/devstoreaccount1/team")
+	if !ok {
+		f.fail(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	name := strings.TrimPrefix(rest, "/")
+	body, _ := io.ReadAll(r.Body)
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		if query.Get("restype") != "container" || query.Get("comp") != "list" {
+			f.fail(w, http.StatusBadRequest, "InvalidQueryParameterValue")
+			return
+		}
+		f.list(w, query.Get("prefix"), query.Get("marker"))
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		if f.staged[name] == nil {
+			f.staged[name] = map[string][]byte{}
+		}
+		f.staged[name][query.Get("blockid")] = body
+		f.blocks++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list azblobBlockList
+		if err := xml.Unmarshal(body, &list); err != nil {
+			f.fail(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var content []byte
+		for _, id := range list.Latest {
+			block, ok := f.staged[name][id]
+			if !ok {
+				f.fail(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			content = append(content, block...)
+		}
+		f.blobs[name] = content
+		delete(f.staged, name)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			f.fail(w, http.StatusBadRequest, "MissingRequiredHeader")
+			return
+		}
+		current, exists := f.blobs[name]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			f.fail(w, http.StatusConflict, "BlobAlreadyExists")
+			return
+		}
+		if r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != azblobFakeETag(current)) {
+			f.fail(w, http.StatusPreconditionFailed, "ConditionNotMet")
+			return
+		}
+		f.blobs[name] = body
+		w.Header().Set("ETag", azblobFakeETag(body))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := f.blobs[name]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		w.Header().Set("ETag", azblobFakeETag(content))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		status := http.StatusOK
+		if offset, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+			start, _ := strconv.Atoi(strings.TrimSuffix(offset, "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			content, status = content[start:], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			f.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb")
+	}
+}
+
+func (f *azblobFake) list(w http.ResponseWriter, prefix string, marker string) {
+	names := make([]string, 0)
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) && name >= marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var out strings.Builder
+	out.WriteString("<EnumerationResults><Blobs>")
+	for i, name := range names {
+		if i == 2 {
+			out.WriteString("</Blobs><NextMarker>" + name + "</NextMarker>")
+			fmt.Fprint(w, out.String()+"</EnumerationResults>")
+			return
+		}
+		fmt.Fprintf(&out, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length><Etag>%s</Etag><Last-Modified>%s</Last-Modified></Properties></Blob>",
+			name, len(f.blobs[name]), azblobFakeETag(f.blobs[name]), time.Now().UTC().Format(http.TimeFormat))
+	}
+	out.WriteString("</Blobs><NextMarker /></EnumerationResults>")
+	fmt.Fprint(w, out.String())
+}
+
+func (f *azblobFake) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func azblobFakeETag(content []byte) string {
+	hash := sha256.Sum256(content)
+	return `"0x` + strings.ToUpper(hex.EncodeToString(hash[:8])) + `"`
+}
@@ -0,0 +1,473 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBackendProvider = "aliyun-oss"
+
+// defaultTransferJobs is the number of concurrent transfers when neither
+// --jobs nor the jobs setting is given.
+const defaultTransferJobs = 8
+
+// reservedNamePrefix marks donk's own metadata objects, such as per-entry
+// manifests, that live next to synced files and are never mirrored.
+const reservedNamePrefix = ".donk-"
+
+type Backend interface {
+	Pull(src string, dst string) error
+	Push(src string, dst string) error
+	ReadObject(src string) ([]byte, error)
+	WriteObject(dst string, content []byte) error
+	List(prefix string) ([]ObjectInfo, error)
+	Delete(path string) error
+	Stat(path string) (ObjectInfo, error)
+}
+
+type ObjectInfo struct {
+	Path    string
+	Size    int64
+	ETag    string
+	ModTime time.Time
+}
+
+// BackendOptions carries per-command settings shared by all backends. Ctx
+// cancels in-flight requests, for example on Ctrl-C, Jobs limits how many
+// objects are transferred at once and Progress receives transfer progress.
+type BackendOptions struct {
+	Ctx      context.Context
+	Jobs     int
+	Progress ProgressReporter
+	// DryRun keeps backends from changing local state such as caches.
+	DryRun bool
+}
+
+type BackendFactory func(cfg OSSConfig, opts BackendOptions) (Backend, error)
+
+type backendProvider struct {
+	name    string
+	factory BackendFactory
+}
+
+// objectStore is implemented by backends that reuse the shared tree mirroring
+// in pullTree and pushTree on top of their single object transfers.
+type objectStore interface {
+	Backend
+	downloadFile(ctx context.Context, src string, dst string) error
+	uploadFile(ctx context.Context, src string, dst string) error
+}
+
+// jobLimiter is implemented by backends that cannot run several transfers at
+// the same time, such as git where every write is a commit in one work tree.
+type jobLimiter interface {
+	maxJobs() int
+}
+
+// transactional is implemented by backends that publish every write as its own
+// change, such as git where each write is a commit and a push. Writes between
+// beginTransaction and commitTransaction are published as one change instead,
+// and abortTransaction drops them. Aborting after a commit does nothing.
+type transactional interface {
+	beginTransaction(root string) error
+	commitTransaction(message string) error
+	abortTransaction()
+}
+
+// ConditionalWriter is implemented by backends that can reject a write when
+// the object changed since it was read. An empty etag means the object must
+// not exist yet.
+type ConditionalWriter interface {
+	WriteObjectIfMatch(dst string, content []byte, etag string) error
+}
+
+// ExclusiveCreator is implemented by backends that cannot compare ETags on
+// write but can refuse to create an object that already exists.
+type ExclusiveCreator interface {
+	CreateObject(dst string, content []byte) error
+}
+
+var backendProviders = map[string]backendProvider{}
+
+var (
+	errRemoteNotFound     = errors.New("remote object or directory was not found")
+	errPreconditionFailed = errors.New("remote object was changed by another writer")
+)
+
+func RegisterBackend(scheme string, provider string, factory BackendFactory) {
+	backendProviders[scheme] = backendProvider{name: provider, factory: factory}
+}
+
+func OpenBackend(settings Settings, uri string, opts BackendOptions) (Backend, error) {
+	scheme := uriScheme(uri)
+	provider, ok := backendProviders[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported remote path because no backend is registered for its scheme: %s", uri)
+	}
+	return provider.factory(settings.resolveRemote(uri), opts)
+}
+
+func (o BackendOptions) ctx() context.Context {
+	if o.Ctx == nil {
+		return context.Background()
+	}
+	return o.Ctx
+}
+
+func (o BackendOptions) progress() ProgressReporter {
+	if o.Progress == nil {
+		return noProgress{}
+	}
+	return o.Progress
+}
+
+// transferJobs returns how many transfers may run at once against backend.
+func transferJobs(backend Backend, opts BackendOptions) int {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = defaultTransferJobs
+	}
+	if limiter, ok := backend.(jobLimiter); ok && limiter.maxJobs() < jobs {
+		jobs = limiter.maxJobs()
+	}
+	return jobs
+}
+
+// runParallel calls task for every index in [0, count) on up to jobs
+// goroutines. The first failure cancels the ctx passed to every task, which
+// stops tasks that have not started yet and aborts transfers that are still
+// running, and all failures are returned together.
+func runParallel(parent context.Context, jobs int, count int, task func(ctx context.Context, idx int) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	if jobs > count {
+		jobs = count
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	next := make(chan int)
+	for worker := 0; worker < jobs; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				if err := task(ctx, idx); err != nil {
+					mu.Lock()
+					// Tasks canceled because a sibling failed only repeat
+					// that failure.
+					if len(errs) == 0 || !errors.Is(err, context.Canceled) {
+						errs = append(errs, err)
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+feed:
+	for idx := 0; idx < count; idx++ {
+		select {
+		case next <- idx:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if err := parent.Err(); err != nil {
+		return fmt.Errorf("transfer was canceled: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+func backendScheme(providerName string) (string, error) {
+	if providerName == "" {
+		providerName = defaultBackendProvider
+	}
+	for scheme, provider := range backendProviders {
+		if provider.name == providerName {
+			return scheme, nil
+		}
+	}
+	return "", fmt.Errorf("the storage provider is not supported: %s", providerName)
+}
+
+// remoteRoots builds the root of providers whose bucket is not a plain bucket
+// name, such as the repository url of git.
+var remoteRoots = map[string]func(bucket string) (string, error){}
+
+func remoteRoot(cfg OSSConfig) (string, error) {
+	if root, ok := remoteRoots[cfg.Name]; ok {
+		return root(cfg.Bucket)
+	}
+	scheme, err := backendScheme(cfg.Name)
+	if err != nil {
+		return "", err
+	}
+	bucket := strings.Trim(cfg.Bucket, "/")
+	if bucket == "" {
+		return "", errors.New("the storage bucket is empty")
+	}
+	return fmt.Sprintf("%s://%s", scheme, bucket), nil
+}
+
+func uriScheme(uri string) string {
+	idx := strings.Index(uri, "://")
+	if idx <= 0 {
+		return ""
+	}
+	return strings.ToLower(uri[:idx])
+}
+
+func joinRemotePath(base, rel string) string {
+	base = strings.TrimSuffix(base, "/")
+	rel = strings.TrimPrefix(rel, "/")
+	if rel == "" {
+		return base
+	}
+	if base == "" {
+		return rel
+	}
+	return base + "/" + rel
+}
+
+// writeObjectIfMatch writes content only when the remote object still has the
+// given etag. Backends without native support get a compare-then-write check,
+// which narrows but does not close the race window, so callers that need the
+// guarantee check conditionalWriteSupported first.
+func writeObjectIfMatch(backend Backend, dst string, content []byte, etag string) error {
+	if writer, ok := backend.(ConditionalWriter); ok {
+		return writer.WriteObjectIfMatch(dst, content, etag)
+	}
+	if creator, ok := backend.(ExclusiveCreator); ok && etag == "" {
+		return creator.CreateObject(dst, content)
+	}
+	current := ""
+	if info, err := backend.Stat(dst); err == nil {
+		current = info.ETag
+	} else if !errors.Is(err, errRemoteNotFound) {
+		return err
+	}
+	if current != etag {
+		return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+	}
+	return backend.WriteObject(dst, content)
+}
+
+// conditionalWriteSupported reports whether writeObjectIfMatch is atomic for
+// the given etag on backend.
+func conditionalWriteSupported(backend Backend, etag string) bool {
+	if _, ok := backend.(ConditionalWriter); ok {
+		return true
+	}
+	_, ok := backend.(ExclusiveCreator)
+	return ok && etag == ""
+}
+
+func pullTree(store objectStore, opts BackendOptions, src string, dst string) error {
+	progress := opts.progress()
+	// Single object path.
+	if info, err := store.Stat(src); err == nil {
+		return pullSingle(opts.ctx(), store, opts, src, dst, info.Size)
+	} else if !errors.Is(err, errRemoteNotFound) {
+		return fmt.Errorf("pull failed while checking whether the remote object exists: %w", err)
+	}
+
+	// Prefix path.
+	objects, err := store.List(src)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	files := make([]ObjectInfo, 0, len(objects))
+	var totalBytes int64
+	for _, obj := range objects {
+		if !isReservedPath(obj.Path) {
+			files = append(files, obj)
+			totalBytes += obj.Size
+		}
+	}
+	progress.AddTotal(len(files), totalBytes)
+	return runParallel(opts.ctx(), transferJobs(store, opts), len(files), func(ctx context.Context, idx int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		localFile := filepath.Join(dst, filepath.FromSlash(files[idx].Path))
+		if err := os.MkdirAll(filepath.Dir(localFile), 0o755); err != nil {
+			return err
+		}
+		if err := store.downloadFile(ctx, joinRemotePath(src, files[idx].Path), localFile); err != nil {
+			return err
+		}
+		progress.AddBytes(files[idx].Size)
+		progress.FileDone()
+		return nil
+	})
+}
+
+func pushTree(store objectStore, opts BackendOptions, src string, dst string) error {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("push failed because the local source path is unavailable: %s", src)
+	}
+	progress := opts.progress()
+	if !stat.IsDir() {
+		return pushSingle(opts.ctx(), store, opts, src, dst, stat.Size())
+	}
+
+	existing, err := store.List(dst)
+	if err != nil && !errors.Is(err, errRemoteNotFound) {
+		return err
+	}
+	files := make([]string, 0)
+	sizes := make([]int64, 0)
+	err = filepath.Walk(src, func(path string, fileInfo os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		sizes = append(sizes, fileInfo.Size())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var totalBytes int64
+	for _, size := range sizes {
+		totalBytes += size
+	}
+	progress.AddTotal(len(files), totalBytes)
+	err = runParallel(opts.ctx(), transferJobs(store, opts), len(files), func(ctx context.Context, idx int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := store.uploadFile(ctx, filepath.Join(src, filepath.FromSlash(files[idx])), joinRemotePath(dst, files[idx])); err != nil {
+			return err
+		}
+		progress.AddBytes(sizes[idx])
+		progress.FileDone()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove remote files that no longer exist locally, keeping donk metadata.
+	uploaded := map[string]bool{}
+	for _, rel := range files {
+		uploaded[rel] = true
+	}
+	for _, obj := range existing {
+		if uploaded[obj.Path] || isReservedPath(obj.Path) {
+			continue
+		}
+		if err := store.Delete(joinRemotePath(dst, obj.Path)); err != nil {
+			return err
+		}
+		progress.FileDeleted()
+	}
+	return nil
+}
+
+// pullFile downloads the single object src to dst like Pull, but under ctx, so
+// a task of runParallel stops its download as soon as a sibling fails.
+func pullFile(ctx context.Context, backend Backend, opts BackendOptions, src string, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store, ok := backend.(objectStore)
+	if !ok {
+		return backend.Pull(src, dst)
+	}
+	info, err := store.Stat(src)
+	if err != nil {
+		return err
+	}
+	return pullSingle(ctx, store, opts, src, dst, info.Size)
+}
+
+// pushFile uploads the single local file src to dst like Push, but under ctx.
+func pushFile(ctx context.Context, backend Backend, opts BackendOptions, src string, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	store, ok := backend.(objectStore)
+	if !ok {
+		return backend.Push(src, dst)
+	}
+	stat, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("push failed because the local source path is unavailable: %s", src)
+	}
+	return pushSingle(ctx, store, opts, src, dst, stat.Size())
+}
+
+func pullSingle(ctx context.Context, store objectStore, opts BackendOptions, src string, dst string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	progress := opts.progress()
+	progress.AddTotal(1, size)
+	if err := store.downloadFile(ctx, src, dst); err != nil {
+		return err
+	}
+	progress.AddBytes(size)
+	progress.FileDone()
+	return nil
+}
+
+func pushSingle(ctx context.Context, store objectStore, opts BackendOptions, src string, dst string, size int64) error {
+	progress := opts.progress()
+	progress.AddTotal(1, size)
+	if err := store.uploadFile(ctx, src, dst); err != nil {
+		return err
+	}
+	progress.AddBytes(size)
+	progress.FileDone()
+	return nil
+}
+
+func isReservedPath(rel string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, reservedNamePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortObjectInfos(objects []ObjectInfo) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Path < objects[j].Path
+	})
+}
@@ -0,0 +1,121 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBackendContract runs the operations every backend shares against an
+// empty remote directory at root: writing, reading, ranges, missing objects,
+// listing, deleting, conditional writes and mirroring a tree.
+func testBackendContract(t *testing.T, b Backend, root string) {
+	t.Helper()
+	ctx := context.Background()
+	object := root + "/donk/a b.txt"
+	missing := root + "/donk/missing"
+
+	if err := b.WriteObject(object, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := b.ReadObject(object); err != nil || string(content) != "hello" {
+		t.Fatalf("ReadObject() = %q, %v, want hello", content, err)
+	}
+	info, err := b.Stat(object)
+	if err != nil || info.Size != 5 || info.ETag == "" {
+		t.Fatalf("Stat() = %+v, %v, want 5 bytes and an etag", info, err)
+	}
+	if reader, ok := b.(RangeReader); ok {
+		var buf bytes.Buffer
+		if err := reader.ReadRange(ctx, object, 2, &buf); err != nil || buf.String() != "llo" {
+			t.Fatalf("ReadRange() = %q, %v, want llo", buf.String(), err)
+		}
+		if err := reader.ReadRange(ctx, missing, 0, &buf); !errors.Is(err, errRemoteNotFound) {
+			t.Fatalf("ReadRange() of a missing object = %v, want %v", err, errRemoteNotFound)
+		}
+	}
+	if store, ok := b.(objectStore); ok {
+		dst := filepath.Join(t.TempDir(), "a b.txt")
+		if err := store.downloadFile(ctx, object, dst); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := os.ReadFile(dst); err != nil || string(content) != "hello" {
+			t.Fatalf("downloadFile() wrote %q, %v, want hello", content, err)
+		}
+		if err := store.downloadFile(ctx, missing, filepath.Join(t.TempDir(), "missing")); !errors.Is(err, errRemoteNotFound) {
+			t.Fatalf("downloadFile() of a missing object = %v, want %v", err, errRemoteNotFound)
+		}
+	}
+	if _, err := b.ReadObject(missing); !errors.Is(err, errRemoteNotFound) {
+		t.Fatalf("ReadObject() of a missing object = %v, want %v", err, errRemoteNotFound)
+	}
+	if _, err := b.Stat(missing); !errors.Is(err, errRemoteNotFound) {
+		t.Fatalf("Stat() of a missing object = %v, want %v", err, errRemoteNotFound)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := b.WriteObject(fmt.Sprintf("%s/donk/list/%d", root, i), []byte{byte('0' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := b.List(root + "/donk/list")
+	if err != nil || len(objects) != 5 || objects[0].Path != "0" || objects[4].Path != "4" {
+		t.Fatalf("List() = %+v, %v, want 0 to 4", objects, err)
+	}
+	if objects, err := b.List(missing); err != nil || len(objects) != 0 {
+		t.Fatalf("List() of a missing prefix = %+v, %v, want nothing", objects, err)
+	}
+	if err := b.Delete(root + "/donk/list"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(missing); err != nil {
+		t.Fatalf("Delete() of a missing path = %v", err)
+	}
+	if objects, err := b.List(root + "/donk/list"); err != nil || len(objects) != 0 {
+		t.Fatalf("List() after Delete() = %+v, %v, want nothing", objects, err)
+	}
+
+	manifest := root + "/donk/manifest"
+	if conditionalWriteSupported(b, "") {
+		if err := writeObjectIfMatch(b, manifest, []byte("1"), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeObjectIfMatch(b, manifest, []byte("1"), ""); !errors.Is(err, errPreconditionFailed) {
+			t.Fatalf("conditional create of an existing object = %v, want %v", err, errPreconditionFailed)
+		}
+	}
+	if conditionalWriteSupported(b, "etag") {
+		info, err := b.Stat(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeObjectIfMatch(b, manifest, []byte("22"), info.ETag); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeObjectIfMatch(b, manifest, []byte("333"), info.ETag); !errors.Is(err, errPreconditionFailed) {
+			t.Fatalf("conditional write with a stale etag = %v, want %v", err, errPreconditionFailed)
+		}
+		if content, err := b.ReadObject(manifest); err != nil || string(content) != "22" {
+			t.Fatalf("ReadObject() after the conflicts = %q, %v, want 22", content, err)
+		}
+	}
+
+	local := t.TempDir()
+	files := map[string]string{"init.lua": "init", "lua/keys.lua": "keys"}
+	writeTestFiles(t, local, files)
+	if err := b.Push(local, root+"/tree"); err != nil {
+		t.Fatal(err)
+	}
+	pulled := filepath.Join(t.TempDir(), "tree")
+	if err := b.Pull(root+"/tree", pulled); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestDir(t, pulled); !maps.Equal(got, files) {
+		t.Fatalf("Pull() after Push() = %v, want %v", got, files)
+	}
+}
@@ -0,0 +1,257 @@
+package src
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// blobStore keeps file contents addressed by their sha256, once per remote
+// under donk/blobs/sha256/<hash> and once per machine in ~/.donk/blobs.
+// Encrypted entries keep their blobs under donk/blobs/encrypted/hmac-sha256,
+// named by an HMAC of the plaintext hash, while the local cache stays
+// plaintext and readable only by the user.
+type blobStore struct {
+	backend Backend
+	opts    BackendOptions
+	remote  string
+	local   string
+	cipher  *blobCipher
+}
+
+func newBlobStore(context Context, entry ConfigEntry, encrypted bool) (blobStore, error) {
+	root, err := context.Settings.entryRemoteRoot(entry)
+	if err != nil {
+		return blobStore{}, err
+	}
+	remoteDir := joinRemotePath(root, defaultBlobOSSPrefix+"/"+cfgManifestAlgorithm)
+	var blobCipher *blobCipher
+	if encrypted {
+		remoteDir = joinRemotePath(root, defaultBlobOSSPrefix+"/"+encryptedBlobDirName+"/"+encryptedBlobNameAlgorithm)
+		if blobCipher, err = newBlobCipher(context.Settings.Encryption); err != nil {
+			return blobStore{}, err
+		}
+	}
+	backend, err := context.openBackend(remoteDir)
+	if err != nil {
+		return blobStore{}, err
+	}
+	return blobStore{
+		backend: backend,
+		opts:    context.backendOptions(),
+		remote:  remoteDir,
+		local:   filepath.Join(context.Dir, "blobs", cfgManifestAlgorithm),
+		cipher:  blobCipher,
+	}, nil
+}
+
+func (b blobStore) remotePath(hash string) (string, error) {
+	if b.cipher == nil {
+		return joinRemotePath(b.remote, hash), nil
+	}
+	name, err := b.cipher.blobName(hash)
+	if err != nil {
+		return "", err
+	}
+	return joinRemotePath(b.remote, name), nil
+}
+
+func (b blobStore) localPath(hash string) string {
+	return filepath.Join(b.local, hash)
+}
+
+// upload stores src under hash unless the remote already has that blob. The
+// file is hashed again right before the upload so a file edited after the
+// snapshot cannot end up under the wrong hash.
+func (b blobStore) upload(ctx context.Context, src string, hash string) error {
+	dst, err := b.remotePath(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := b.backend.Stat(dst); err == nil {
+		b.opts.progress().FileSkipped()
+		return nil
+	} else if !errors.Is(err, errRemoteNotFound) {
+		return fmt.Errorf("blob upload failed while checking whether the remote blob exists: %w", err)
+	}
+
+	if b.cipher != nil {
+		return b.uploadEncrypted(ctx, src, hash, dst)
+	}
+	current, err := fileSHA256(src)
+	if err != nil {
+		return err
+	}
+	if current != hash {
+		return fmt.Errorf("blob upload failed because the file changed while it was being pushed: %s", src)
+	}
+	return pushFile(ctx, b.backend, b.opts, src, dst)
+}
+
+// uploadEncrypted hashes and encrypts the same read of src, so the uploaded
+// ciphertext always matches hash.
+func (b blobStore) uploadEncrypted(ctx context.Context, src string, hash string, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if current := sha256.Sum256(content); hex.EncodeToString(current[:]) != hash {
+		return fmt.Errorf("blob upload failed because the file changed while it was being pushed: %s", src)
+	}
+	encrypted, err := b.cipher.encrypt(content)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "donk-blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encrypted); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return pushFile(ctx, b.backend, b.opts, tmp.Name(), dst)
+}
+
+// uploadAll uploads the given hash to local file pairs in parallel.
+func (b blobStore) uploadAll(sources map[string]string) error {
+	hashes := make([]string, 0, len(sources))
+	for hash := range sources {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return runParallel(b.opts.ctx(), transferJobs(b.backend, b.opts), len(hashes), func(ctx context.Context, idx int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return b.upload(ctx, sources[hashes[idx]], hashes[idx])
+	})
+}
+
+// fetchAll makes sure every hash is in the local cache, downloading missing
+// blobs in parallel.
+func (b blobStore) fetchAll(hashes []string) error {
+	unique := make([]string, 0, len(hashes))
+	seen := map[string]bool{}
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, hash)
+		}
+	}
+	return runParallel(b.opts.ctx(), transferJobs(b.backend, b.opts), len(unique), func(ctx context.Context, idx int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := b.fetch(ctx, unique[idx])
+		return err
+	})
+}
+
+// fetch returns the path of the blob in the local cache, downloading and
+// verifying it first when it is missing.
+func (b blobStore) fetch(ctx context.Context, hash string) (string, error) {
+	cached := b.localPath(hash)
+	if _, err := os.Stat(cached); err == nil {
+		b.opts.progress().FileSkipped()
+		return cached, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := b.ensureLocalDir(); err != nil {
+		return "", err
+	}
+	src, err := b.remotePath(hash)
+	if err != nil {
+		return "", err
+	}
+	tmp := cached + ".download"
+	_ = os.Remove(tmp)
+	if err := pullFile(ctx, b.backend, b.opts, src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("blob download failed for %s: %w", hash, err)
+	}
+	if b.cipher != nil {
+		if err := b.decryptFile(tmp); err != nil {
+			_ = os.Remove(tmp)
+			return "", fmt.Errorf("blob download failed for %s: %w", hash, err)
+		}
+	}
+	actual, err := fileSHA256(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if actual != hash {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("blob download failed because the content does not match its sha256. Expected: %s. Actual: %s", hash, actual)
+	}
+	if err := os.Rename(tmp, cached); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return cached, nil
+}
+
+func (b blobStore) decryptFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	plain, err := b.cipher.decrypt(content)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(path, plain, 0o600)
+}
+
+// ensureLocalDir creates the local blob cache readable only by the user, as
+// it holds the plaintext of encrypted entries. Caches created by an older
+// version with a wider mode are narrowed.
+func (b blobStore) ensureLocalDir() error {
+	if err := os.MkdirAll(b.local, 0o700); err != nil {
+		return err
+	}
+	return os.Chmod(b.local, 0o700)
+}
+
+// seed adds a local file to the blob cache so that restoring it elsewhere
+// needs no download. A file that no longer matches hash is ignored.
+func (b blobStore) seed(src string, hash string) error {
+	cached := b.localPath(hash)
+	if _, err := os.Stat(cached); err == nil {
+		return nil
+	}
+	if err := b.ensureLocalDir(); err != nil {
+		return err
+	}
+	tmp := cached + ".seed"
+	if err := copyFile(src, tmp); err != nil {
+		return err
+	}
+	if b.cipher != nil {
+		if err := os.Chmod(tmp, 0o600); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	actual, err := fileSHA256(tmp)
+	if err != nil || actual != hash {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, cached)
+}
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -11,17 +12,27 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
-const cfgUsageText = "usage: donk cfg pull <name> | donk cfg push <name> | donk cfg init <name>"
+const cfgUsageText = "usage: donk cfg pull <name> [--force | --prefer-local | --prefer-remote] [--dry-run] | donk cfg push <name> [--force | --prefer-local | --prefer-remote] [--dry-run] | donk cfg init <name> [--dry-run] | donk cfg log <name> | donk cfg checkout <name> <rev> [--push] | donk cfg status [name...] [--json] | donk cfg diff <name> [--rev <n>] [--stat] [--name-only] | donk cfg resolve <name>, with optional --jobs <n>"
 
 const (
-	cfgManifestVersion   = 1
-	cfgManifestAlgorithm = "sha256"
+	cfgManifestVersion     = 1
+	cfgManifestAlgorithm   = "sha256"
+	cfgManifestMaxAttempts = 5
+	cfgEntryManifestName   = reservedNamePrefix + "manifest.json"
+	cfgIndexName           = reservedNamePrefix + "index.json"
+	cfgLegacyManifestName  = "manifest.json"
+	cfgRevisionsDirName    = reservedNamePrefix + "revs"
+	cfgStorageBlobs        = "blobs"
 )
 
+var errCfgPushedConcurrently = errors.New("another machine pushed the same entry concurrently")
+
 type CfgCmd struct {
 	Context Context
 }
@@ -30,6 +41,8 @@ type CfgManifest struct {
 	Version   int                         `json:"version"`
 	Algorithm string                      `json:"algorithm"`
 	Entries   map[string]CfgManifestEntry `json:"entries"`
+	// Merges holds entries whose last merge still has conflicts.
+	Merges map[string]CfgMergeState `json:"merges,omitempty"`
 }
 
 type CfgManifestEntry struct {
@@ -39,6 +52,34 @@ type CfgManifestEntry struct {
 	UpdatedBy      string            `json:"updated_by"`
 	Files          []CfgManifestFile `json:"files"`
 	ManifestSHA256 string            `json:"manifest_sha256"`
+	// Storage is "blobs" when file contents live in the blob store. Entries
+	// pushed by older versions keep a plain copy of the files at cfg[].oss.
+	Storage string `json:"storage,omitempty"`
+	// Encrypted is set when the blobs of the entry are encrypted. Hashes
+	// are always computed on the plaintext.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// CfgRemoteManifest is stored next to the synced files of each entry at
+// donk/cfg/<name>/.donk-manifest.json.
+type CfgRemoteManifest struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	Name      string `json:"name"`
+	CfgManifestEntry
+}
+
+// CfgIndex summarizes all entries of a remote at donk/cfg/.donk-index.json.
+type CfgIndex struct {
+	Version int                      `json:"version"`
+	Entries map[string]CfgIndexEntry `json:"entries"`
+}
+
+type CfgIndexEntry struct {
+	Revision       int64  `json:"revision"`
+	UpdatedAt      string `json:"updated_at"`
+	UpdatedBy      string `json:"updated_by"`
+	ManifestSHA256 string `json:"manifest_sha256"`
 }
 
 type CfgManifestFile struct {
@@ -52,13 +93,65 @@ func CreateCfgCmd(context Context) CfgCmd {
 }
 
 func (c CfgCmd) Run(args []string) error {
+	if len(args) < 2 || args[0] != "cfg" {
+		return fmt.Errorf("invalid command arguments. %s", cfgUsageText)
+	}
+	boolFlags, valueFlags := []string{}, []string{"jobs"}
+	switch args[1] {
+	case "pull", "push":
+		boolFlags = append(boolFlags, "force", "prefer-local", "prefer-remote", "dry-run")
+	case "init":
+		boolFlags = append(boolFlags, "dry-run")
+	case "checkout":
+		boolFlags = append(boolFlags, "push")
+	case "status":
+		boolFlags = append(boolFlags, "json")
+	case "diff":
+		boolFlags = append(boolFlags, "stat", "name-only")
+		valueFlags = append(valueFlags, "rev")
+	}
+	parsed, err := parseCmdArgs(args[2:], boolFlags, valueFlags)
+	if err != nil {
+		return fmt.Errorf("invalid command arguments because %w. %s", err, cfgUsageText)
+	}
+	if err := c.Context.applyJobsFlag(parsed); err != nil {
+		return err
+	}
+	strategy, err := parseCfgStrategy(parsed)
+	if err != nil {
+		return fmt.Errorf("invalid command arguments because %w. %s", err, cfgUsageText)
+	}
+	c.Context.DryRun = parsed.has("dry-run")
+
+	positional := parsed.positional
 	switch {
-	case len(args) == 3 && args[0] == "cfg" && args[1] == "pull":
-		return c.Pull(args[2])
-	case len(args) == 3 && args[0] == "cfg" && args[1] == "push":
-		return c.Push(args[2])
-	case len(args) == 3 && args[0] == "cfg" && args[1] == "init":
-		return c.Init(args[2])
+	case args[1] == "pull" && len(positional) == 1:
+		return c.Pull(positional[0], strategy)
+	case args[1] == "push" && len(positional) == 1:
+		return c.Push(positional[0], strategy)
+	case args[1] == "init" && len(positional) == 1:
+		return c.Init(positional[0])
+	case args[1] == "log" && len(positional) == 1:
+		return c.Log(positional[0])
+	case args[1] == "checkout" && len(positional) == 2:
+		revision, err := strconv.ParseInt(positional[1], 10, 64)
+		if err != nil || revision <= 0 {
+			return fmt.Errorf("invalid command arguments because the revision is not a positive number: %s. %s", positional[1], cfgUsageText)
+		}
+		return c.Checkout(positional[0], revision, parsed.has("push"))
+	case args[1] == "status":
+		return c.Status(positional, parsed.has("json"))
+	case args[1] == "diff" && len(positional) == 1:
+		revision := int64(0)
+		if parsed.has("rev") {
+			revision, err = strconv.ParseInt(parsed.value("rev"), 10, 64)
+			if err != nil || revision <= 0 {
+				return fmt.Errorf("invalid command arguments because the revision is not a positive number: %s. %s", parsed.value("rev"), cfgUsageText)
+			}
+		}
+		return c.Diff(positional[0], revision, parsed.has("stat"), parsed.has("name-only"))
+	case args[1] == "resolve" && len(positional) == 1:
+		return c.Resolve(positional[0])
 	default:
 		return fmt.Errorf("invalid command arguments. %s", cfgUsageText)
 	}
@@ -118,10 +211,23 @@ func (c CfgCmd) Init(name string) error {
 		return err
 	}
 
+	if c.Context.DryRun {
+		plan := &dryRunPlan{}
+		plan.add("copy", "%s to %s", primaryLinkPath, localCfgDir)
+		if err := c.push(name, CfgStrategyNone, primaryLinkPath, plan); err != nil {
+			return err
+		}
+		plan.add("remove", "%s", primaryLinkPath)
+		plan.addSymlinks(linkPlans)
+		plan.addCommands(entry.Cmd)
+		plan.print(fmt.Sprintf("configuration init dry run for: %s. Nothing was changed", name))
+		return nil
+	}
+
 	if err := c.copyDirForCfgInit(primaryLinkPath, localCfgDir); err != nil {
 		return err
 	}
-	if err := c.Push(name); err != nil {
+	if err := c.Push(name, CfgStrategyNone); err != nil {
 		return err
 	}
 	if err := os.RemoveAll(primaryLinkPath); err != nil {
@@ -138,13 +244,34 @@ func (c CfgCmd) Init(name string) error {
 	return nil
 }
 
-func (c CfgCmd) Pull(name string) error {
+// Pull updates the local files of an entry to the remote revision. With a
+// strategy other than CfgStrategyNone, the revision guards are bypassed and
+// local files that would be replaced are backed up first.
+func (c CfgCmd) Pull(name string, strategy CfgStrategy) error {
+	if !c.Context.DryRun {
+		return c.pull(name, strategy, nil)
+	}
+	plan := &dryRunPlan{}
+	if err := c.pull(name, strategy, plan); err != nil {
+		return err
+	}
+	plan.print(fmt.Sprintf("configuration pull dry run for: %s. Nothing was changed", name))
+	return nil
+}
+
+// pull adds its steps to plan instead of running them when plan is not nil.
+func (c CfgCmd) pull(name string, strategy CfgStrategy, plan *dryRunPlan) error {
 	entry, err := findEntry(c.Context.Settings.Cfg, name)
 	if err != nil {
 		return err
 	}
+	if plan == nil {
+		if err := CreateLockCmd(c.Context).checkCfgUnlocked(entry); err != nil {
+			return err
+		}
+	}
 
-	remoteManifest, err := c.loadRemoteCfgManifest()
+	remoteManifestEntry, isRemoteManifestExists, _, err := c.loadRemoteCfgEntry(entry, false)
 	if err != nil {
 		return err
 	}
@@ -154,7 +281,31 @@ func (c CfgCmd) Pull(name string) error {
 		return err
 	}
 
-	remoteManifestEntry, isRemoteManifestExists := remoteManifest.Entries[name]
+	// Local files are backed up once, right before a strategy lets pull
+	// replace local changes.
+	backedUp := false
+	backup := func() error {
+		if backedUp || strategy == CfgStrategyNone || !isRemoteManifestExists {
+			return nil
+		}
+		backedUp = true
+		return c.backupLocalCfgDir(name, remoteManifestEntry, plan)
+	}
+	if _, ok := localManifest.Merges[name]; ok {
+		if strategy == CfgStrategyNone {
+			return c.checkNoPendingCfgMerge(localManifest, name, "pull")
+		}
+		keepLocal := strategy.resolution(false) == mergeTakeLocal
+		if !keepLocal {
+			if err := backup(); err != nil {
+				return err
+			}
+		}
+		if err := c.settleCfgMerge(&localManifest, name, keepLocal, plan); err != nil {
+			return err
+		}
+	}
+
 	localManifestEntry, isLocalManifestExists := localManifest.Entries[name]
 	remoteRevision := int64(0)
 	if isRemoteManifestExists {
@@ -168,20 +319,21 @@ func (c CfgCmd) Pull(name string) error {
 	localCfgDir := c.buildLocalCfgDir(name)
 
 	doPull := func() error {
-		tempLocalCfgDir := localCfgDir + ".tmp"
-		if err := os.RemoveAll(tempLocalCfgDir); err != nil {
+		if err := backup(); err != nil {
 			return err
 		}
-		if err := os.MkdirAll(filepath.Dir(localCfgDir), 0o755); err != nil {
+		changes, err := c.restoreCfgFiles(entry, remoteManifestEntry, entry.OSS, localCfgDir, plan)
+		if err != nil {
 			return err
 		}
-		if err := c.pullSource(entry.OSS, tempLocalCfgDir); err != nil {
-			_ = os.RemoveAll(tempLocalCfgDir)
+		symlinkPlans, err := buildSymlinkPlans(entry.Name, entry.Link, localCfgDir)
+		if err != nil {
 			return err
 		}
-		if err := c.renameDir(localCfgDir, tempLocalCfgDir); err != nil {
-			_ = os.RemoveAll(tempLocalCfgDir)
-			return err
+		if plan != nil {
+			plan.addSymlinks(symlinkPlans)
+			plan.addCommands(entry.Cmd)
+			return nil
 		}
 
 		if localManifest.Entries == nil {
@@ -192,24 +344,32 @@ func (c CfgCmd) Pull(name string) error {
 			return err
 		}
 
-		symlinkPlans, err := buildSymlinkPlans(entry.Name, entry.Link, localCfgDir)
-		if err != nil {
-			return err
-		}
 		if err := ensureSymlinks(symlinkPlans); err != nil {
 			return err
 		}
+		finishProgress(c.Context.Progress)
 		if err := runCommands(entry.Cmd); err != nil {
 			return err
 		}
 
-		fmt.Printf("configuration pull completed successfully for: %s\n", name)
+		fmt.Printf("configuration pull completed successfully for: %s. %s\n", name, changes)
 
 		return nil
 	}
 
+	if isRemoteManifestExists && strategy == CfgStrategyForce {
+		return doPull()
+	}
+
 	switch {
 	case localRevision > remoteRevision:
+		if isRemoteManifestExists && strategy == CfgStrategyPreferRemote {
+			return doPull()
+		}
+		if strategy == CfgStrategyPreferLocal {
+			fmt.Printf("configuration pull kept the local content because the remote revision is older for: %s. Local revision: %d. Remote revision: %d\n", name, localRevision, remoteRevision)
+			return nil
+		}
 		return fmt.Errorf("configuration pull cannot continue because the local revision is newer than the remote revision. Local revision: %d. Remote revision: %d. Please run cfg push first", localRevision, remoteRevision)
 	case localRevision == remoteRevision:
 		if !isRemoteManifestExists {
@@ -234,14 +394,34 @@ func (c CfgCmd) Pull(name string) error {
 		if err != nil {
 			return err
 		}
-		if isEqual {
+		switch {
+		case isEqual:
 			fmt.Printf("configuration pull was skipped because local and remote content are already identical for: %s\n", name)
 			return nil
-		} else {
+		case strategy == CfgStrategyPreferRemote:
+			return doPull()
+		case strategy == CfgStrategyPreferLocal:
+			fmt.Printf("configuration pull kept the local changes because the remote has no newer revision for: %s. Revision: %d\n", name, localRevision)
+			return nil
+		default:
 			return fmt.Errorf("configuration pull failed because local content differs from the remote manifest at the same revision. Revision: %d. Name: %s", localRevision, name)
 		}
 	case localRevision < remoteRevision:
 		if isRemoteManifestExists {
+			if isLocalManifestExists {
+				isEqual, err := c.isLocalCfgEqualToManifest(localCfgDir, localManifestEntry)
+				if err != nil {
+					return err
+				}
+				if !isEqual {
+					if strategy.resolution(false) == mergeTakeRemote {
+						if err := backup(); err != nil {
+							return err
+						}
+					}
+					return c.pullMerge(entry, &localManifest, localManifestEntry, remoteManifestEntry, strategy.resolution(false), plan)
+				}
+			}
 			return doPull()
 		} else {
 			return fmt.Errorf("configuration pull failed because the remote manifest entry is missing. Name: %s. Remote revision: %d", name, remoteRevision)
@@ -251,18 +431,107 @@ func (c CfgCmd) Pull(name string) error {
 	return nil
 }
 
-func (c CfgCmd) Push(name string) error {
+// pullMerge merges a newer remote revision into a local directory that has
+// changes of its own. The merged result stays local until it is pushed.
+func (c CfgCmd) pullMerge(entry ConfigEntry, localManifest *CfgManifest, base CfgManifestEntry, remote CfgManifestEntry, resolution mergeResolution, plan *dryRunPlan) error {
+	localCfgDir := c.buildLocalCfgDir(entry.Name)
+	symlinkPlans, err := buildSymlinkPlans(entry.Name, entry.Link, localCfgDir)
+	if err != nil {
+		return err
+	}
+	if plan != nil {
+		if _, err := c.planCfgMerge(plan, base, remote, localCfgDir); err != nil {
+			return err
+		}
+		plan.addSymlinks(symlinkPlans)
+		plan.addCommands(entry.Cmd)
+		return nil
+	}
+
+	result, err := c.mergeCfgEntry(entry, base, remote, localCfgDir, resolution)
+	if err != nil {
+		return err
+	}
+	if err := c.recordCfgMerge(localManifest, entry.Name, remote, result.conflicts); err != nil {
+		return err
+	}
+	if err := ensureSymlinks(symlinkPlans); err != nil {
+		return err
+	}
+	finishProgress(c.Context.Progress)
+	if len(result.conflicts) > 0 {
+		return fmt.Errorf("configuration pull merged remote revision %d but found conflicts in: %s. Fix the files, then run donk cfg resolve %s", remote.Revision, strings.Join(result.conflicts, ", "), entry.Name)
+	}
+	if err := runCommands(entry.Cmd); err != nil {
+		return err
+	}
+	fmt.Printf("configuration pull merged remote revision %d with local changes for: %s. %s. Run donk cfg push %s to publish the merge\n", remote.Revision, entry.Name, result, entry.Name)
+	return nil
+}
+
+// Push publishes the local files of an entry as a new revision. With a
+// strategy other than CfgStrategyNone, the revision guards are bypassed and
+// whatever would be replaced is backed up first.
+func (c CfgCmd) Push(name string, strategy CfgStrategy) error {
+	if !c.Context.DryRun {
+		return c.push(name, strategy, c.buildLocalCfgDir(name), nil)
+	}
+	plan := &dryRunPlan{}
+	if err := c.push(name, strategy, c.buildLocalCfgDir(name), plan); err != nil {
+		return err
+	}
+	plan.print(fmt.Sprintf("configuration push dry run for: %s. Nothing was changed", name))
+	return nil
+}
+
+// push publishes the files in localCfgDir. It adds its steps to plan instead
+// of running them when plan is not nil, and then takes no lease either.
+func (c CfgCmd) push(name string, strategy CfgStrategy, localCfgDir string, plan *dryRunPlan) (err error) {
 	entry, err := findEntry(c.Context.Settings.Cfg, name)
 	if err != nil {
 		return err
 	}
 
-	localCfgDir := c.buildLocalCfgDir(name)
 	if _, err := os.Stat(localCfgDir); err != nil {
 		return fmt.Errorf("configuration push failed because the local source directory does not exist: %s", localCfgDir)
 	}
 
-	remoteManifest, err := c.loadRemoteCfgManifest()
+	var lease *cfgLease
+	commitTx := func() error { return nil }
+	if plan == nil {
+		var tx transactional
+		if tx, err = c.beginCfgTransaction(entry); err != nil {
+			return err
+		}
+		if tx != nil {
+			// The transaction lands as one commit that others see all at
+			// once or not at all, and its manifest write is conditional, so
+			// it takes no lease.
+			defer tx.abortTransaction()
+			commitTx = func() error {
+				return tx.commitTransaction(fmt.Sprintf("donk: push %s", name))
+			}
+		} else {
+			lease, err = CreateLockCmd(c.Context).acquireCfgLock(entry)
+			if err != nil {
+				return err
+			}
+			defer lease.release()
+			// Everything the push does runs under a context that is canceled
+			// when the lease cannot be renewed.
+			ctx, cancel := context.WithCancelCause(c.Context.ctx())
+			defer cancel(nil)
+			lease.keepAlive(cancel)
+			c.Context.Ctx = ctx
+			defer func() {
+				if cause := context.Cause(ctx); err != nil && errors.Is(cause, errCfgLeaseLost) {
+					err = cause
+				}
+			}()
+		}
+	}
+
+	remoteEntry, remoteExists, remoteManifestETag, err := c.loadRemoteCfgEntry(entry, plan == nil)
 	if err != nil {
 		return err
 	}
@@ -271,7 +540,6 @@ func (c CfgCmd) Push(name string) error {
 		return err
 	}
 
-	remoteEntry, remoteExists := remoteManifest.Entries[name]
 	localEntry, localExists := localManifest.Entries[name]
 	remoteRevision := int64(0)
 	if remoteExists {
@@ -282,7 +550,53 @@ func (c CfgCmd) Push(name string) error {
 		localRevision = localEntry.Revision
 	}
 
-	if localRevision < remoteRevision {
+	_, mergePending := localManifest.Merges[name]
+	if mergePending && strategy == CfgStrategyNone {
+		return c.checkNoPendingCfgMerge(localManifest, name, "push")
+	}
+	// A push from a directory that diverged merges the remote revision first
+	// and pushes the result on top of it. Without a base revision that only
+	// happens when a strategy settles the conflicts.
+	needsMerge := localRevision < remoteRevision && strategy != CfgStrategyForce && (localExists || strategy != CfgStrategyNone)
+	// Settling for the remote side can replace local changes, and settling for
+	// the local side or forcing a stale push can drop remote ones. Only those
+	// cases keep a backup of the side that loses.
+	backupLocal := strategy.resolution(true) == mergeTakeRemote && (mergePending || needsMerge)
+	backupRemote := strategy.resolution(true) == mergeTakeLocal && (mergePending || localRevision < remoteRevision)
+	if backupLocal && remoteExists {
+		if err := c.backupLocalCfgDir(name, remoteEntry, plan); err != nil {
+			return err
+		}
+	}
+	if mergePending {
+		if err := c.settleCfgMerge(&localManifest, name, strategy.resolution(true) == mergeTakeLocal, plan); err != nil {
+			return err
+		}
+	}
+
+	var mergedFiles []CfgManifestFile
+	if needsMerge && plan != nil {
+		if mergedFiles, err = c.planCfgMerge(plan, localEntry, remoteEntry, localCfgDir); err != nil {
+			return err
+		}
+		localRevision = remoteRevision
+	} else if needsMerge {
+		result, err := c.mergeCfgEntry(entry, localEntry, remoteEntry, localCfgDir, strategy.resolution(true))
+		if err != nil {
+			return err
+		}
+		if err := c.recordCfgMerge(&localManifest, name, remoteEntry, result.conflicts); err != nil {
+			return err
+		}
+		if len(result.conflicts) > 0 {
+			finishProgress(c.Context.Progress)
+			return fmt.Errorf("configuration push cannot continue because merging remote revision %d found conflicts in: %s. Fix the files, then run donk cfg resolve %s", remoteRevision, strings.Join(result.conflicts, ", "), name)
+		}
+		fmt.Printf("configuration push merged remote revision %d for: %s. %s\n", remoteRevision, name, result)
+		localRevision = remoteRevision
+	}
+
+	if localRevision < remoteRevision && strategy == CfgStrategyNone {
 		return fmt.Errorf("configuration push cannot continue because the local revision is behind the remote revision. Local revision: %d. Remote revision: %d. Please run donk cfg pull %s first", localRevision, remoteRevision, name)
 	}
 
@@ -290,38 +604,179 @@ func (c CfgCmd) Push(name string) error {
 	if err != nil {
 		return err
 	}
-	if remoteExists && c.isCfgManifestFilesEqual(files, remoteEntry.Files) {
+	if mergedFiles != nil {
+		files = mergedFiles
+	}
+	if remoteExists && remoteEntry.Encrypted == entry.Encrypt && c.isCfgManifestFilesEqual(files, remoteEntry.Files) {
 		fmt.Printf("configuration push was skipped because local and remote content are already identical for: %s\n", name)
 		return nil
 	}
 
-	newRevision := localRevision + 1
+	if backupRemote && remoteExists {
+		if err := c.backupRemoteCfgEntry(entry, remoteEntry, plan); err != nil {
+			return err
+		}
+	}
+
+	newRevision := max(localRevision, remoteRevision) + 1
 	newEntry, err := c.buildManifestEntry(localCfgDir, newRevision, files)
 	if err != nil {
 		return err
 	}
+	newEntry.Encrypted = entry.Encrypt
 
-	if err := c.pushSource(localCfgDir, entry.OSS); err != nil {
+	changes := c.diffCfgFiles(remoteEntry.Files, files)
+	if err := c.pushCfgBlobs(entry, localCfgDir, files, remoteEntry, plan); err != nil {
+		return err
+	}
+	if plan != nil {
+		// Blobs and earlier revisions are kept, so a push deletes no remote
+		// objects. Removed files only leave the new manifest.
+		for _, file := range changes.removed {
+			plan.add("delete", "%s from the remote manifest", file.Path)
+		}
+		plan.add("write", "remote manifest for revision %d", newRevision)
+		return nil
+	}
+	// Manifests must not be written under a lease that was lost while the
+	// blobs were uploaded.
+	if err := context.Cause(c.Context.ctx()); err != nil {
+		return err
+	}
+	if err := c.saveRemoteCfgRevision(entry, newEntry); err != nil {
 		return err
 	}
 
+	if err := c.saveRemoteCfgEntry(entry, newEntry, remoteManifestETag, lease); err != nil {
+		return err
+	}
+	c.updateRemoteCfgIndex(entry, newEntry)
+	if err := commitTx(); err != nil {
+		if errors.Is(err, errPreconditionFailed) {
+			return c.pushedConcurrentlyErr(entry)
+		}
+		return err
+	}
 	if localManifest.Entries == nil {
 		localManifest.Entries = map[string]CfgManifestEntry{}
 	}
-	if remoteManifest.Entries == nil {
-		remoteManifest.Entries = map[string]CfgManifestEntry{}
-	}
 	localManifest.Entries[name] = newEntry
-	remoteManifest.Entries[name] = newEntry
+	if err := c.saveLocalCfgManifest(c.buildLocalCfgManifestPath(), localManifest); err != nil {
+		return err
+	}
+
+	finishProgress(c.Context.Progress)
+	fmt.Printf("configuration push completed successfully for: %s. %s\n", name, changes)
+	return nil
+}
 
-	if err := c.saveRemoteCfgManifest(remoteManifest); err != nil {
+func (c CfgCmd) Log(name string) error {
+	entry, err := findEntry(c.Context.Settings.Cfg, name)
+	if err != nil {
 		return err
 	}
-	if err := c.saveLocalCfgManifest(c.buildLocalCfgManifestPath(), localManifest); err != nil {
+	revisions, err := c.listRemoteCfgRevisions(entry)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		fmt.Printf("no revision history exists on the remote for: %s\n", name)
+		return nil
+	}
+	current, _, _, err := c.loadRemoteCfgEntry(entry, false)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tUPDATED AT\tUPDATED BY\tFILES\t")
+	for _, revision := range revisions {
+		marker := ""
+		if revision.Revision == current.Revision {
+			marker = "(current)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", revision.Revision, revision.UpdatedAt, revision.UpdatedBy, len(revision.Files), marker)
+	}
+	return w.Flush()
+}
+
+// Checkout restores an old revision into the local cfg directory. The local
+// manifest records the restored files at the current remote revision, so a
+// following push stores them as a new revision on top of it.
+func (c CfgCmd) Checkout(name string, revision int64, push bool) error {
+	entry, err := findEntry(c.Context.Settings.Cfg, name)
+	if err != nil {
+		return err
+	}
+	revisionEntry, err := c.loadRemoteCfgRevision(entry, revision)
+	if err != nil {
+		return err
+	}
+	remoteEntry, remoteExists, _, err := c.loadRemoteCfgEntry(entry, false)
+	if err != nil {
+		return err
+	}
+	localManifest, err := c.loadLocalCfgManifest(c.buildLocalCfgManifestPath())
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("configuration push completed successfully for: %s\n", name)
+	if err := c.checkNoPendingCfgMerge(localManifest, name, "checkout"); err != nil {
+		return err
+	}
+
+	// Local files must match the last synced revision. Files that were never
+	// synced exist nowhere else, so they are backed up before the checkout
+	// replaces them.
+	localCfgDir := c.buildLocalCfgDir(name)
+	if localEntry, ok := localManifest.Entries[name]; ok {
+		isEqual, err := c.isLocalCfgEqualToManifest(localCfgDir, localEntry)
+		if err != nil {
+			return err
+		}
+		if !isEqual {
+			return fmt.Errorf("configuration checkout failed because the local directory has changes that were not pushed. Name: %s. Please run donk cfg push %s first", name, name)
+		}
+	} else if err := c.backupLocalCfgDir(name, revisionEntry, nil); err != nil {
+		return err
+	}
+
+	revisionDir, err := c.buildRemoteCfgRevisionPath(entry, revision)
+	if err != nil {
+		return err
+	}
+	if _, err := c.restoreCfgFiles(entry, revisionEntry, revisionDir, localCfgDir, nil); err != nil {
+		return err
+	}
+
+	if remoteExists {
+		checkedOut := revisionEntry
+		checkedOut.Revision = remoteEntry.Revision
+		if localManifest.Entries == nil {
+			localManifest.Entries = map[string]CfgManifestEntry{}
+		}
+		localManifest.Entries[name] = checkedOut
+		if err := c.saveLocalCfgManifest(c.buildLocalCfgManifestPath(), localManifest); err != nil {
+			return err
+		}
+	}
+
+	symlinkPlans, err := buildSymlinkPlans(entry.Name, entry.Link, localCfgDir)
+	if err != nil {
+		return err
+	}
+	if err := ensureSymlinks(symlinkPlans); err != nil {
+		return err
+	}
+	finishProgress(c.Context.Progress)
+	if err := runCommands(entry.Cmd); err != nil {
+		return err
+	}
+	fmt.Printf("configuration checkout completed successfully for: %s. Revision: %d\n", name, revision)
+
+	if push {
+		return c.Push(name, CfgStrategyNone)
+	}
 	return nil
 }
 
@@ -333,102 +788,547 @@ func (c CfgCmd) buildLocalCfgManifestPath() string {
 	return filepath.Join(c.Context.Dir, "cfg", "manifest.json")
 }
 
-func (c CfgCmd) buildRemoteManifestPath() string {
-	bucket := strings.Trim(c.Context.Settings.OSS.Bucket, "/")
-	return fmt.Sprintf("oss://%s/donk/cfg/manifest.json", bucket)
+// buildRemoteCfgPath joins rel onto the cfg prefix of the entry's remote,
+// where per-entry manifests, the index and the legacy manifest live.
+func (c CfgCmd) buildRemoteCfgPath(entry ConfigEntry, rel string) (string, error) {
+	root, err := c.Context.Settings.entryRemoteRoot(entry)
+	if err != nil {
+		return "", err
+	}
+	return joinRemotePath(joinRemotePath(root, defaultCfgOSSPrefix), rel), nil
+}
+
+func (c CfgCmd) buildRemoteEntryManifestPath(entry ConfigEntry) (string, error) {
+	return c.buildRemoteCfgPath(entry, entry.Name+"/"+cfgEntryManifestName)
 }
 
 func (c CfgCmd) loadLocalCfgManifest(path string) (CfgManifest, error) {
-	manifest := c.defaultCfgManifest()
 	content, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return manifest, nil
+			return c.defaultCfgManifest(), nil
 		}
 		return CfgManifest{}, err
 	}
-	if len(strings.TrimSpace(string(content))) == 0 {
-		return manifest, nil
+	return c.decodeCfgManifest(content)
+}
+
+func (c CfgCmd) saveLocalCfgManifest(path string, manifest CfgManifest) error {
+	content, err := c.encodeCfgManifest(manifest)
+	if err != nil {
+		return err
 	}
-	if err := json.Unmarshal(content, &manifest); err != nil {
-		return CfgManifest{}, fmt.Errorf("failed to parse configuration manifest file: %w", err)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
 	}
-	if manifest.Entries == nil {
-		manifest.Entries = map[string]CfgManifestEntry{}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
 	}
-	if manifest.Version == 0 {
-		manifest.Version = cfgManifestVersion
+
+	return os.Rename(tmp, path)
+}
+
+func (c CfgCmd) buildRemoteCfgRevisionPath(entry ConfigEntry, revision int64) (string, error) {
+	return c.buildRemoteCfgPath(entry, fmt.Sprintf("%s/%s/%d", entry.Name, cfgRevisionsDirName, revision))
+}
+
+// beginCfgTransaction makes every remote write of a push one change on
+// backends that would otherwise publish each write separately. It pins the
+// backend into the context, so blobs and manifests all go through it, and
+// returns nil when the backend of the entry has no transactions.
+func (c *CfgCmd) beginCfgTransaction(entry ConfigEntry) (transactional, error) {
+	root, err := c.Context.Settings.entryRemoteRoot(entry)
+	if err != nil {
+		return nil, err
 	}
-	if manifest.Algorithm == "" {
-		manifest.Algorithm = cfgManifestAlgorithm
+	backend, err := c.Context.openBackend(root)
+	if err != nil {
+		return nil, err
 	}
-	return manifest, nil
+	tx, ok := backend.(transactional)
+	if !ok {
+		return nil, nil
+	}
+	if err := tx.beginTransaction(root); err != nil {
+		return nil, err
+	}
+	backends := map[string]Backend{uriScheme(root): backend}
+	for scheme, other := range c.Context.Backends {
+		if scheme != uriScheme(root) {
+			backends[scheme] = other
+		}
+	}
+	c.Context.Backends = backends
+	return tx, nil
 }
 
-func (c CfgCmd) saveLocalCfgManifest(path string, manifest CfgManifest) error {
-	if manifest.Version == 0 {
-		manifest.Version = cfgManifestVersion
+// saveRemoteCfgRevision stores the manifest of a pushed revision. Its files
+// are already in the blob store, so keeping history costs one small object.
+func (c CfgCmd) saveRemoteCfgRevision(entry ConfigEntry, manifestEntry CfgManifestEntry) error {
+	dir, err := c.buildRemoteCfgRevisionPath(entry, manifestEntry.Revision)
+	if err != nil {
+		return err
 	}
-	if manifest.Algorithm == "" {
-		manifest.Algorithm = cfgManifestAlgorithm
+	content, err := c.encodeRemoteCfgEntry(entry, manifestEntry)
+	if err != nil {
+		return err
 	}
-	if manifest.Entries == nil {
-		manifest.Entries = map[string]CfgManifestEntry{}
+	dst := joinRemotePath(dir, cfgEntryManifestName)
+	backend, err := c.Context.openBackend(dst)
+	if err != nil {
+		return err
+	}
+	if err := backend.WriteObject(dst, content); err != nil {
+		return fmt.Errorf("configuration push failed while saving revision %d: %w", manifestEntry.Revision, err)
 	}
+	return nil
+}
 
-	content, err := json.MarshalIndent(manifest, "", "  ")
+// pushCfgBlobs uploads the contents of files that the remote does not have
+// yet. Blobs referenced by the previous remote revision are known to exist.
+func (c CfgCmd) pushCfgBlobs(entry ConfigEntry, localCfgDir string, files []CfgManifestFile, remoteEntry CfgManifestEntry, plan *dryRunPlan) error {
+	known := map[string]bool{}
+	if remoteEntry.Storage == cfgStorageBlobs && remoteEntry.Encrypted == entry.Encrypt {
+		for _, file := range remoteEntry.Files {
+			known[file.SHA256] = true
+		}
+	}
+	sources := map[string]string{}
+	for _, file := range files {
+		if known[file.SHA256] {
+			continue
+		}
+		if _, ok := sources[file.SHA256]; !ok && plan != nil {
+			plan.add("upload", "%s (%s)", file.Path, formatBytes(file.Size))
+		}
+		sources[file.SHA256] = filepath.Join(localCfgDir, filepath.FromSlash(file.Path))
+	}
+	if plan != nil {
+		return nil
+	}
+	store, err := newBlobStore(c.Context, entry, entry.Encrypt)
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+	return store.uploadAll(sources)
+}
+
+// restoreCfgFiles makes localCfgDir match the files of a manifest entry and
+// reports what changed. Blob based entries are updated in place, transferring
+// only added or changed files. Older entries are pulled in full from their
+// plain copy at legacySrc.
+func (c CfgCmd) restoreCfgFiles(entry ConfigEntry, manifestEntry CfgManifestEntry, legacySrc string, localCfgDir string, plan *dryRunPlan) (cfgFileChanges, error) {
+	localFiles, err := c.buildCfgFileSnapshot(localCfgDir)
+	if err != nil {
+		return cfgFileChanges{}, err
+	}
+	changes := c.diffCfgFiles(localFiles, manifestEntry.Files)
+	if plan != nil {
+		if manifestEntry.Storage != cfgStorageBlobs {
+			plan.add("download", "%s to %s, replacing the directory", legacySrc, localCfgDir)
+			return changes, nil
+		}
+		for _, file := range append(append([]CfgManifestFile(nil), changes.added...), changes.changed...) {
+			plan.add("download", "%s (%s)", file.Path, formatBytes(file.Size))
+		}
+		for _, file := range changes.removed {
+			plan.add("delete", "%s", file.Path)
+		}
+		return changes, nil
+	}
+	if manifestEntry.Storage != cfgStorageBlobs {
+		return changes, c.replaceCfgDir(entry, manifestEntry, legacySrc, localCfgDir)
+	}
+	if err := os.MkdirAll(localCfgDir, 0o755); err != nil {
+		return cfgFileChanges{}, err
+	}
+	if changes.isEmpty() {
+		return changes, nil
+	}
+
+	store, err := newBlobStore(c.Context, entry, manifestEntry.Encrypted)
+	if err != nil {
+		return cfgFileChanges{}, err
+	}
+	// Files that were only renamed or copied locally need no download.
+	localByHash := map[string]string{}
+	for _, file := range localFiles {
+		localByHash[file.SHA256] = filepath.Join(localCfgDir, filepath.FromSlash(file.Path))
+	}
+	updates := append(append([]CfgManifestFile(nil), changes.added...), changes.changed...)
+	for _, file := range updates {
+		if src, ok := localByHash[file.SHA256]; ok {
+			if err := store.seed(src, file.SHA256); err != nil {
+				return cfgFileChanges{}, err
+			}
+		}
+	}
+
+	hashes := make([]string, 0, len(updates))
+	for _, file := range updates {
+		if !isWithinDir(localCfgDir, filepath.Join(localCfgDir, filepath.FromSlash(file.Path))) {
+			return cfgFileChanges{}, fmt.Errorf("configuration pull failed because a manifest path escapes the cfg directory: %s", file.Path)
+		}
+		hashes = append(hashes, file.SHA256)
+	}
+	if err := store.fetchAll(hashes); err != nil {
+		return cfgFileChanges{}, err
+	}
+
+	for _, file := range updates {
+		target := filepath.Join(localCfgDir, filepath.FromSlash(file.Path))
+		if err := copyFile(store.localPath(file.SHA256), target); err != nil {
+			return cfgFileChanges{}, err
+		}
+	}
+	for _, file := range changes.removed {
+		if err := c.removeCfgFile(localCfgDir, filepath.Join(localCfgDir, filepath.FromSlash(file.Path))); err != nil {
+			return cfgFileChanges{}, err
+		}
+	}
+	return changes, nil
+}
+
+// removeCfgFile deletes a file and drops directories that became empty,
+// stopping at the first one that still has content.
+func (c CfgCmd) removeCfgFile(localCfgDir string, target string) error {
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+	c.Context.backendOptions().progress().FileDeleted()
+	for dir := filepath.Dir(target); dir != localCfgDir && isWithinDir(localCfgDir, dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// replaceCfgDir downloads a full copy of an entry pushed by an older version
+// into a temp directory, verifies it against the manifest and swaps it in.
+func (c CfgCmd) replaceCfgDir(entry ConfigEntry, manifestEntry CfgManifestEntry, src string, localCfgDir string) error {
+	tempLocalCfgDir := localCfgDir + ".tmp"
+	if err := os.RemoveAll(tempLocalCfgDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localCfgDir), 0o755); err != nil {
+		return err
+	}
+	lock := CreateLockCmd(c.Context)
+	if err := lock.checkCfgUnlocked(entry); err != nil {
+		return err
+	}
+	if err := c.pullSource(src, tempLocalCfgDir); err != nil {
+		_ = os.RemoveAll(tempLocalCfgDir)
 		return err
 	}
+	// A push that started during the download may have left a mix of old
+	// and new files behind.
+	if err := lock.checkCfgUnlocked(entry); err != nil {
+		_ = os.RemoveAll(tempLocalCfgDir)
+		return err
+	}
+	isEqual, err := c.isLocalCfgEqualToManifest(tempLocalCfgDir, manifestEntry)
+	if err != nil || !isEqual {
+		_ = os.RemoveAll(tempLocalCfgDir)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("configuration pull failed because the downloaded files do not match the manifest of revision %d. Name: %s", manifestEntry.Revision, entry.Name)
+	}
+	if err := c.renameDir(localCfgDir, tempLocalCfgDir); err != nil {
+		_ = os.RemoveAll(tempLocalCfgDir)
+		return err
+	}
+	return nil
+}
 
-	return os.Rename(tmp, path)
+func (c CfgCmd) loadRemoteCfgRevision(entry ConfigEntry, revision int64) (CfgManifestEntry, error) {
+	dir, err := c.buildRemoteCfgRevisionPath(entry, revision)
+	if err != nil {
+		return CfgManifestEntry{}, err
+	}
+	src := joinRemotePath(dir, cfgEntryManifestName)
+	content, _, err := c.readRemoteObject(src)
+	if err != nil {
+		if c.isRemoteNotFoundErr(err) {
+			return CfgManifestEntry{}, fmt.Errorf("configuration revision was not found on the remote. Name: %s. Revision: %d", entry.Name, revision)
+		}
+		return CfgManifestEntry{}, err
+	}
+	var manifest CfgRemoteManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return CfgManifestEntry{}, fmt.Errorf("failed to parse remote configuration manifest %s: %w", src, err)
+	}
+	return manifest.CfgManifestEntry, nil
 }
 
-func (c CfgCmd) loadRemoteCfgManifest() (CfgManifest, error) {
-	tmpFile, err := os.CreateTemp("", "donk-cfg-remote-manifest-*.json")
+// listRemoteCfgRevisions returns the saved revisions of an entry, newest first.
+func (c CfgCmd) listRemoteCfgRevisions(entry ConfigEntry) ([]CfgManifestEntry, error) {
+	dir, err := c.buildRemoteCfgPath(entry, entry.Name+"/"+cfgRevisionsDirName)
 	if err != nil {
-		return CfgManifest{}, err
+		return nil, err
+	}
+	backend, err := c.Context.openBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := backend.List(dir)
+	if err != nil {
+		if c.isRemoteNotFoundErr(err) {
+			return []CfgManifestEntry{}, nil
+		}
+		return nil, err
 	}
-	dst := tmpFile.Name()
-	_ = tmpFile.Close()
-	_ = os.Remove(dst)
-	defer os.Remove(dst)
 
-	src := c.buildRemoteManifestPath()
-	if err := c.pullSource(src, dst); err != nil {
-		if c.isOSSNotFoundErr(err) {
-			return c.defaultCfgManifest(), nil
+	revisions := make([]CfgManifestEntry, 0)
+	for _, obj := range objects {
+		revisionText, rest, ok := strings.Cut(obj.Path, "/")
+		if !ok || rest != cfgEntryManifestName {
+			continue
+		}
+		revision, err := strconv.ParseInt(revisionText, 10, 64)
+		if err != nil {
+			continue
+		}
+		manifestEntry, err := c.loadRemoteCfgRevision(entry, revision)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, manifestEntry)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision > revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// loadRemoteCfgEntry returns the remote manifest of one entry together with
+// the ETag it was read at, which saveRemoteCfgEntry uses for its conditional
+// write. Entries that only exist in the legacy manifest are read from there,
+// and with migrate they are copied into their own manifest first. Only push
+// migrates, so read-only commands never change the remote.
+func (c CfgCmd) loadRemoteCfgEntry(entry ConfigEntry, migrate bool) (CfgManifestEntry, bool, string, error) {
+	src, err := c.buildRemoteEntryManifestPath(entry)
+	if err != nil {
+		return CfgManifestEntry{}, false, "", err
+	}
+	content, etag, err := c.readRemoteObject(src)
+	if err != nil {
+		if !c.isRemoteNotFoundErr(err) {
+			return CfgManifestEntry{}, false, "", err
+		}
+		if !migrate {
+			legacyEntry, ok, err := c.loadLegacyCfgEntry(entry)
+			return legacyEntry, ok, "", err
+		}
+		migrated, err := c.migrateLegacyCfgEntry(entry, src)
+		if err != nil || !migrated {
+			return CfgManifestEntry{}, false, "", err
+		}
+		if content, etag, err = c.readRemoteObject(src); err != nil {
+			return CfgManifestEntry{}, false, "", err
 		}
-		return CfgManifest{}, err
 	}
 
-	return c.loadLocalCfgManifest(dst)
+	var manifest CfgRemoteManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return CfgManifestEntry{}, false, "", fmt.Errorf("failed to parse remote configuration manifest %s: %w", src, err)
+	}
+	if manifest.Name != "" && manifest.Name != entry.Name {
+		return CfgManifestEntry{}, false, "", fmt.Errorf("remote configuration manifest belongs to a different entry. Path: %s. Name: %s", src, manifest.Name)
+	}
+	return manifest.CfgManifestEntry, true, etag, nil
 }
 
-func (c CfgCmd) saveRemoteCfgManifest(manifest CfgManifest) error {
-	tmpFile, err := os.CreateTemp("", "donk-cfg-remote-manifest-*.json")
+// saveRemoteCfgEntry publishes a pushed revision with a conditional write on
+// the ETag the remote manifest was read at. Backends that cannot write
+// conditionally are only trusted while lease is still held.
+func (c CfgCmd) saveRemoteCfgEntry(entry ConfigEntry, manifestEntry CfgManifestEntry, etag string, lease *cfgLease) error {
+	dst, err := c.buildRemoteEntryManifestPath(entry)
+	if err != nil {
+		return err
+	}
+	content, err := c.encodeRemoteCfgEntry(entry, manifestEntry)
 	if err != nil {
 		return err
 	}
-	src := tmpFile.Name()
-	_ = tmpFile.Close()
-	defer os.Remove(src)
+	backend, err := c.Context.openBackend(dst)
+	if err != nil {
+		return err
+	}
+	if !conditionalWriteSupported(backend, etag) {
+		if lease == nil {
+			return fmt.Errorf("configuration push failed because the remote of %s cannot update its manifest with a conditional write and the remote lock is not held", entry.Name)
+		}
+		if err := lease.verify(); err != nil {
+			return fmt.Errorf("configuration push failed because the remote of %s cannot update its manifest with a conditional write and %w: %w", entry.Name, errCfgLeaseLost, err)
+		}
+	}
+	if err := writeObjectIfMatch(backend, dst, content, etag); err != nil {
+		if errors.Is(err, errPreconditionFailed) {
+			return c.pushedConcurrentlyErr(entry)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c CfgCmd) pushedConcurrentlyErr(entry ConfigEntry) error {
+	return fmt.Errorf("configuration push failed because %w. Name: %s. Please run donk cfg pull %s and push again", errCfgPushedConcurrently, entry.Name, entry.Name)
+}
+
+// migrateLegacyCfgEntry copies the entry out of the global manifest.json used
+// by older versions into its own manifest. The legacy file is left in place so
+// older clients keep working.
+func (c CfgCmd) migrateLegacyCfgEntry(entry ConfigEntry, dst string) (bool, error) {
+	legacyEntry, ok, err := c.loadLegacyCfgEntry(entry)
+	if err != nil || !ok {
+		return false, err
+	}
+	content, err := c.encodeRemoteCfgEntry(entry, legacyEntry)
+	if err != nil {
+		return false, err
+	}
+	backend, err := c.Context.openBackend(dst)
+	if err != nil {
+		return false, err
+	}
+	// Another machine migrating the entry first is just as good.
+	if err := writeObjectIfMatch(backend, dst, content, ""); err != nil && !errors.Is(err, errPreconditionFailed) {
+		return false, fmt.Errorf("failed to migrate legacy configuration manifest entry %s: %w", entry.Name, err)
+	}
+	return true, nil
+}
+
+func (c CfgCmd) loadLegacyCfgEntry(entry ConfigEntry) (CfgManifestEntry, bool, error) {
+	src, err := c.buildRemoteCfgPath(entry, cfgLegacyManifestName)
+	if err != nil {
+		return CfgManifestEntry{}, false, err
+	}
+	content, _, err := c.readRemoteObject(src)
+	if err != nil {
+		if c.isRemoteNotFoundErr(err) {
+			return CfgManifestEntry{}, false, nil
+		}
+		return CfgManifestEntry{}, false, err
+	}
+	legacy, err := c.decodeCfgManifest(content)
+	if err != nil {
+		return CfgManifestEntry{}, false, err
+	}
+	legacyEntry, ok := legacy.Entries[entry.Name]
+	return legacyEntry, ok, nil
+}
+
+func (c CfgCmd) encodeRemoteCfgEntry(entry ConfigEntry, manifestEntry CfgManifestEntry) ([]byte, error) {
+	return json.MarshalIndent(CfgRemoteManifest{
+		Version:          cfgManifestVersion,
+		Algorithm:        cfgManifestAlgorithm,
+		Name:             entry.Name,
+		CfgManifestEntry: manifestEntry,
+	}, "", "  ")
+}
+
+// updateRemoteCfgIndex records the entry in the index that lists all entries
+// of a remote. The index is only a summary, so losing a race with another
+// machine is retried and a final failure is reported as a warning.
+func (c CfgCmd) updateRemoteCfgIndex(entry ConfigEntry, manifestEntry CfgManifestEntry) {
+	dst, err := c.buildRemoteCfgPath(entry, cfgIndexName)
+	if err == nil {
+		err = c.writeRemoteCfgIndex(dst, entry.Name, manifestEntry)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to update remote configuration index for %s: %v\n", entry.Name, err)
+	}
+}
 
-	// todo: refine this
-	if err := c.saveLocalCfgManifest(src, manifest); err != nil {
+func (c CfgCmd) writeRemoteCfgIndex(dst string, name string, manifestEntry CfgManifestEntry) error {
+	backend, err := c.Context.openBackend(dst)
+	if err != nil {
 		return err
 	}
+	for attempt := 1; ; attempt++ {
+		index := CfgIndex{Version: cfgManifestVersion, Entries: map[string]CfgIndexEntry{}}
+		content, etag, err := c.readRemoteObject(dst)
+		if err == nil {
+			if err := json.Unmarshal(content, &index); err != nil {
+				return fmt.Errorf("failed to parse remote configuration index %s: %w", dst, err)
+			}
+			if index.Entries == nil {
+				index.Entries = map[string]CfgIndexEntry{}
+			}
+		} else if !c.isRemoteNotFoundErr(err) {
+			return err
+		}
+		index.Entries[name] = CfgIndexEntry{
+			Revision:       manifestEntry.Revision,
+			UpdatedAt:      manifestEntry.UpdatedAt,
+			UpdatedBy:      manifestEntry.UpdatedBy,
+			ManifestSHA256: manifestEntry.ManifestSHA256,
+		}
+		if content, err = json.MarshalIndent(index, "", "  "); err != nil {
+			return err
+		}
+		err = writeObjectIfMatch(backend, dst, content, etag)
+		if err == nil || !errors.Is(err, errPreconditionFailed) || attempt >= cfgManifestMaxAttempts {
+			return err
+		}
+	}
+}
 
-	dst := c.buildRemoteManifestPath()
+// readRemoteObject returns the content of a remote object and the ETag it was
+// read at.
+func (c CfgCmd) readRemoteObject(src string) ([]byte, string, error) {
+	backend, err := c.Context.openBackend(src)
+	if err != nil {
+		return nil, "", err
+	}
+	// Stat before reading so that a concurrent change makes the ETag stale
+	// rather than newer than the content.
+	info, err := backend.Stat(src)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := backend.ReadObject(src)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, info.ETag, nil
+}
 
-	return c.pushSource(src, dst)
+func (c CfgCmd) decodeCfgManifest(content []byte) (CfgManifest, error) {
+	manifest := c.defaultCfgManifest()
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return manifest, nil
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return CfgManifest{}, fmt.Errorf("failed to parse configuration manifest file: %w", err)
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]CfgManifestEntry{}
+	}
+	if manifest.Version == 0 {
+		manifest.Version = cfgManifestVersion
+	}
+	if manifest.Algorithm == "" {
+		manifest.Algorithm = cfgManifestAlgorithm
+	}
+	return manifest, nil
+}
+
+func (c CfgCmd) encodeCfgManifest(manifest CfgManifest) ([]byte, error) {
+	if manifest.Version == 0 {
+		manifest.Version = cfgManifestVersion
+	}
+	if manifest.Algorithm == "" {
+		manifest.Algorithm = cfgManifestAlgorithm
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]CfgManifestEntry{}
+	}
+	return json.MarshalIndent(manifest, "", "  ")
 }
 
 func (c CfgCmd) defaultCfgManifest() CfgManifest {
@@ -439,8 +1339,8 @@ func (c CfgCmd) defaultCfgManifest() CfgManifest {
 	}
 }
 
-func (c CfgCmd) isOSSNotFoundErr(err error) bool {
-	return errors.Is(err, errOSSObjectOrPrefixNotFound)
+func (c CfgCmd) isRemoteNotFoundErr(err error) bool {
+	return errors.Is(err, errRemoteNotFound)
 }
 
 func (c CfgCmd) buildCfgFileSnapshot(root string) ([]CfgManifestFile, error) {
@@ -456,6 +1356,9 @@ func (c CfgCmd) buildCfgFileSnapshot(root string) ([]CfgManifestFile, error) {
 		if err != nil {
 			return err
 		}
+		if strings.HasSuffix(path, cfgRemoteSidecarSuffix) {
+			return nil
+		}
 		if !info.Mode().IsRegular() {
 			return fmt.Errorf("configuration snapshot failed because a non regular file was found: %s", path)
 		}
@@ -463,7 +1366,7 @@ func (c CfgCmd) buildCfgFileSnapshot(root string) ([]CfgManifestFile, error) {
 		if err != nil {
 			return err
 		}
-		sha256, err := c.fileSHA256(path)
+		sha256, err := fileSHA256(path)
 		if err != nil {
 			return err
 		}
@@ -486,20 +1389,6 @@ func (c CfgCmd) buildCfgFileSnapshot(root string) ([]CfgManifestFile, error) {
 	return files, nil
 }
 
-func (c CfgCmd) fileSHA256(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
-
 func (c CfgCmd) manifestFilesSHA256(files []CfgManifestFile) (string, error) {
 	content, err := json.Marshal(files)
 	if err != nil {
@@ -514,21 +1403,15 @@ func (c CfgCmd) buildManifestEntry(root string, revision int64, files []CfgManif
 	if err != nil {
 		return CfgManifestEntry{}, err
 	}
-	updatedBy := os.Getenv("USER")
-	host, _ := os.Hostname()
-	if updatedBy == "" {
-		updatedBy = "unknown"
-	}
-	if host == "" {
-		host = "unknown"
-	}
+	_, updatedBy := updatedByIdentity()
 	return CfgManifestEntry{
 		Root:           root,
 		Revision:       revision,
 		UpdatedAt:      time.Now().UTC().Format(time.RFC3339),
-		UpdatedBy:      updatedBy + "@" + host,
+		UpdatedBy:      updatedBy,
 		Files:          files,
 		ManifestSHA256: manifestHash,
+		Storage:        cfgStorageBlobs,
 	}, nil
 }
 
@@ -548,6 +1431,47 @@ func (c CfgCmd) isCfgManifestFilesEqual(a []CfgManifestFile, b []CfgManifestFile
 	return true
 }
 
+// cfgFileChanges lists the files that differ between two snapshots.
+type cfgFileChanges struct {
+	added   []CfgManifestFile
+	changed []CfgManifestFile
+	removed []CfgManifestFile
+}
+
+func (c cfgFileChanges) isEmpty() bool {
+	return len(c.added) == 0 && len(c.changed) == 0 && len(c.removed) == 0
+}
+
+func (c cfgFileChanges) String() string {
+	return fmt.Sprintf("Added: %d. Changed: %d. Removed: %d", len(c.added), len(c.changed), len(c.removed))
+}
+
+// diffCfgFiles returns what has to change to turn from into to. Added and
+// changed files carry their new size and hash, removed ones their old.
+func (c CfgCmd) diffCfgFiles(from []CfgManifestFile, to []CfgManifestFile) cfgFileChanges {
+	fromByPath := map[string]CfgManifestFile{}
+	for _, file := range from {
+		fromByPath[file.Path] = file
+	}
+	changes := cfgFileChanges{}
+	for _, file := range to {
+		old, ok := fromByPath[file.Path]
+		switch {
+		case !ok:
+			changes.added = append(changes.added, file)
+		case old.Size != file.Size || old.SHA256 != file.SHA256:
+			changes.changed = append(changes.changed, file)
+		}
+		delete(fromByPath, file.Path)
+	}
+	for _, file := range from {
+		if _, ok := fromByPath[file.Path]; ok {
+			changes.removed = append(changes.removed, file)
+		}
+	}
+	return changes
+}
+
 func (c CfgCmd) isLocalCfgEqualToManifest(root string, entry CfgManifestEntry) (bool, error) {
 	files, err := c.buildCfgFileSnapshot(root)
 	if err != nil {
@@ -651,9 +1575,9 @@ func (c CfgCmd) copyDirForCfgInit(src string, dst string) error {
 }
 
 func (c CfgCmd) pushSource(src string, dst string) error {
-	return pushSource(c.Context.Settings, src, dst)
+	return pushSource(c.Context, src, dst)
 }
 
 func (c CfgCmd) pullSource(src string, dst string) error {
-	return pullSource(c.Context.Settings, src, dst)
+	return pullSource(c.Context, src, dst)
 }
@@ -0,0 +1,1249 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testMachine is one home directory syncing the nvim entry with a shared
+// remote.
+type testMachine struct {
+	t       *testing.T
+	context Context
+	link    string
+}
+
+func newTestMachine(t *testing.T, remote Backend) *testMachine {
+	t.Helper()
+	link := filepath.Join(t.TempDir(), "nvim")
+	return &testMachine{
+		t:    t,
+		link: link,
+		context: Context{
+			Dir: t.TempDir(),
+			Settings: Settings{
+				OSS: OSSConfig{Name: "aliyun-oss", Bucket: "team"},
+				Cfg: []ConfigEntry{{Name: "nvim", OSS: "oss://team/donk/cfg/nvim", Link: LinkConfig{link}}},
+			},
+			Backends: map[string]Backend{"oss": remote},
+		},
+	}
+}
+
+func (m *testMachine) cfg() CfgCmd {
+	return CreateCfgCmd(m.context)
+}
+
+func (m *testMachine) entry() ConfigEntry {
+	return m.context.Settings.Cfg[0]
+}
+
+func (m *testMachine) dir() string {
+	return m.cfg().buildLocalCfgDir("nvim")
+}
+
+func (m *testMachine) push(strategy CfgStrategy) error {
+	return m.cfg().Push("nvim", strategy)
+}
+
+func (m *testMachine) pull(strategy CfgStrategy) error {
+	return m.cfg().Pull("nvim", strategy)
+}
+
+func (m *testMachine) mustPush() {
+	m.t.Helper()
+	if err := m.push(CfgStrategyNone); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func (m *testMachine) mustPull() {
+	m.t.Helper()
+	if err := m.pull(CfgStrategyNone); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// write sets the content of local files, removing those set to "".
+func (m *testMachine) write(files map[string]string) {
+	m.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(m.dir(), filepath.FromSlash(name))
+		if content == "" {
+			if err := os.Remove(path); err != nil {
+				m.t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			m.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			m.t.Fatal(err)
+		}
+	}
+}
+
+func (m *testMachine) files() map[string]string {
+	m.t.Helper()
+	return readTestDir(m.t, m.dir())
+}
+
+// setLocalRevision pretends the machine last synced the given revision.
+func (m *testMachine) setLocalRevision(revision int64) {
+	m.t.Helper()
+	path := m.cfg().buildLocalCfgManifestPath()
+	manifest, err := m.cfg().loadLocalCfgManifest(path)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	entry := manifest.Entries["nvim"]
+	entry.Revision = revision
+	manifest.Entries["nvim"] = entry
+	if err := m.cfg().saveLocalCfgManifest(path, manifest); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+func (m *testMachine) remoteRevision() int64 {
+	m.t.Helper()
+	entry, _, _, err := m.cfg().loadRemoteCfgEntry(m.entry(), false)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return entry.Revision
+}
+
+// remoteFiles returns the files of the latest remote revision, as a new
+// machine would pull them.
+func remoteFiles(t *testing.T, remote Backend) map[string]string {
+	t.Helper()
+	fresh := newTestMachine(t, remote)
+	if err := fresh.pull(CfgStrategyNone); err != nil {
+		t.Fatal(err)
+	}
+	return fresh.files()
+}
+
+func readTestDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// captureStdout returns what run prints to stdout.
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(r)
+		out <- string(content)
+	}()
+	runErr := run()
+	_ = w.Close()
+	os.Stdout = stdout
+	return <-out, runErr
+}
+
+func checkTestErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %v, want one containing %q", err, want)
+	}
+}
+
+func TestCfgPull(t *testing.T) {
+	base := map[string]string{"init.lua": "a\nb\nc\n"}
+	tests := []struct {
+		name     string
+		setup    func(other *testMachine, local *testMachine)
+		strategy CfgStrategy
+		wantErr  string
+		want     map[string]string
+	}{
+		{
+			name:  "nothing to pull",
+			setup: func(other *testMachine, local *testMachine) {},
+			want:  map[string]string{},
+		},
+		{
+			name: "local files without a remote revision",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+			},
+			wantErr: "the remote manifest entry is missing at the same revision",
+		},
+		{
+			name: "new machine",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+			},
+			want: base,
+		},
+		{
+			name: "same revision and content",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+			},
+			want: base,
+		},
+		{
+			name: "same revision with local changes",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			wantErr: "local content differs from the remote manifest at the same revision",
+		},
+		{
+			name: "same revision with local changes prefers remote",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n", "extra.lua": "extra\n"})
+			},
+			strategy: CfgStrategyPreferRemote,
+			want:     base,
+		},
+		{
+			name: "same revision with local changes prefers local",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			strategy: CfgStrategyPreferLocal,
+			want:     map[string]string{"init.lua": "local\n"},
+		},
+		{
+			name: "same revision with local changes forced",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			strategy: CfgStrategyForce,
+			want:     base,
+		},
+		{
+			name: "local revision newer",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.setLocalRevision(5)
+			},
+			wantErr: "the local revision is newer than the remote revision",
+		},
+		{
+			name: "local revision newer prefers local",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+				local.setLocalRevision(5)
+			},
+			strategy: CfgStrategyPreferLocal,
+			want:     map[string]string{"init.lua": "local\n"},
+		},
+		{
+			name: "local revision newer prefers remote",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+				local.setLocalRevision(5)
+			},
+			strategy: CfgStrategyPreferRemote,
+			want:     base,
+		},
+		{
+			name: "remote revision newer",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "A\nb\nc\n", "lua/plugins.lua": "plugins\n"})
+				other.mustPush()
+			},
+			want: map[string]string{"init.lua": "A\nb\nc\n", "lua/plugins.lua": "plugins\n"},
+		},
+		{
+			name: "remote revision newer merges local changes",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "A\nb\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nb\nC\n"})
+			},
+			want: map[string]string{"init.lua": "A\nb\nC\n"},
+		},
+		{
+			name: "remote revision newer with conflicts",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nL\nc\n"})
+			},
+			wantErr: "found conflicts in: init.lua",
+		},
+		{
+			name: "remote revision newer with conflicts prefers remote",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nL\nc\n"})
+			},
+			strategy: CfgStrategyPreferRemote,
+			want:     map[string]string{"init.lua": "a\nR\nc\n"},
+		},
+		{
+			name: "entry locked by a running push",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				if _, err := CreateLockCmd(other.context).acquireCfgLock(other.entry()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "nvim is locked by",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			other, local := newTestMachine(t, remote), newTestMachine(t, remote)
+			tt.setup(other, local)
+			checkTestErr(t, local.pull(tt.strategy), tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := local.files(); !maps.Equal(got, tt.want) {
+				t.Fatalf("local files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCfgPush(t *testing.T) {
+	base := map[string]string{"init.lua": "a\nb\nc\n"}
+	tests := []struct {
+		name         string
+		setup        func(other *testMachine, local *testMachine)
+		strategy     CfgStrategy
+		dryRun       bool
+		wantErr      string
+		wantRevision int64
+		wantRemote   map[string]string
+	}{
+		{
+			name: "first push",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+			},
+			wantRevision: 1,
+			wantRemote:   base,
+		},
+		{
+			name: "unchanged",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+				local.mustPush()
+			},
+			wantRevision: 1,
+			wantRemote:   base,
+		},
+		{
+			name: "new revision",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+				local.mustPush()
+				local.write(map[string]string{"init.lua": "A\nb\nc\n", "lua/plugins.lua": "plugins\n"})
+			},
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "A\nb\nc\n", "lua/plugins.lua": "plugins\n"},
+		},
+		{
+			name: "removed file",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(map[string]string{"init.lua": "a\n", "old.lua": "old\n"})
+				local.mustPush()
+				local.write(map[string]string{"old.lua": ""})
+			},
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "a\n"},
+		},
+		{
+			name: "behind without local changes",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "A\nb\nc\n"})
+				other.mustPush()
+			},
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "A\nb\nc\n"},
+		},
+		{
+			name: "behind merges local changes",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "A\nb\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nb\nC\n"})
+			},
+			wantRevision: 3,
+			wantRemote:   map[string]string{"init.lua": "A\nb\nC\n"},
+		},
+		{
+			name: "behind with conflicts",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nL\nc\n"})
+			},
+			wantErr:      "found conflicts in: init.lua",
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "a\nR\nc\n"},
+		},
+		{
+			name: "behind with conflicts prefers local",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nL\nc\n"})
+			},
+			strategy:     CfgStrategyPreferLocal,
+			wantRevision: 3,
+			wantRemote:   map[string]string{"init.lua": "a\nL\nc\n"},
+		},
+		{
+			name: "pending merge",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "a\nL\nc\n"})
+				if err := local.push(CfgStrategyNone); err == nil {
+					t.Fatal("push with conflicts succeeded")
+				}
+			},
+			wantErr:      "a merge has unresolved conflicts in: init.lua",
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "a\nR\nc\n"},
+		},
+		{
+			name: "behind without a synced revision",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			wantErr:      "the local revision is behind the remote revision",
+			wantRevision: 1,
+			wantRemote:   base,
+		},
+		{
+			name: "behind without a synced revision forced",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			strategy:     CfgStrategyForce,
+			wantRevision: 2,
+			wantRemote:   map[string]string{"init.lua": "local\n"},
+		},
+		{
+			name: "entry locked by another push",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				local.write(map[string]string{"init.lua": "local\n"})
+				if _, err := CreateLockCmd(other.context).acquireCfgLock(other.entry()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr:      "nvim is locked by",
+			wantRevision: 1,
+		},
+		{
+			name: "dry run",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+				local.mustPush()
+				local.write(map[string]string{"init.lua": "local\n"})
+			},
+			dryRun:       true,
+			wantRevision: 1,
+			wantRemote:   base,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			other, local := newTestMachine(t, remote), newTestMachine(t, remote)
+			tt.setup(other, local)
+			local.context.DryRun = tt.dryRun
+			checkTestErr(t, local.push(tt.strategy), tt.wantErr)
+			if got := local.remoteRevision(); got != tt.wantRevision {
+				t.Fatalf("remote revision = %d, want %d", got, tt.wantRevision)
+			}
+			if tt.wantErr == "" {
+				if _, exists, _, _ := CreateLockCmd(local.context).readCfgLock(local.entry()); exists {
+					t.Fatal("push left its lock behind")
+				}
+			}
+			if tt.wantRemote == nil {
+				return
+			}
+			if got := remoteFiles(t, remote); !maps.Equal(got, tt.wantRemote) {
+				t.Fatalf("remote files = %v, want %v", got, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestCfgLegacyManifestMigration(t *testing.T) {
+	remote := newMemBackend(BackendOptions{})
+	m := newTestMachine(t, remote)
+
+	// Older versions kept a plain copy of the files at cfg[].oss and listed
+	// every entry in donk/cfg/manifest.json.
+	legacyDir := t.TempDir()
+	writeTestFiles(t, legacyDir, map[string]string{"init.lua": "legacy\n"})
+	files, err := m.cfg().buildCfgFileSnapshot(legacyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyEntry, err := m.cfg().buildManifestEntry(legacyDir, 3, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyEntry.Storage = ""
+	content, err := m.cfg().encodeCfgManifest(CfgManifest{Entries: map[string]CfgManifestEntry{"nvim": legacyEntry}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteObject("oss://team/donk/cfg/manifest.json", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteObject("oss://team/donk/cfg/nvim/init.lua", []byte("legacy\n")); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := func() map[string]string {
+		objects, err := remote.List("oss://team/donk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		etags := map[string]string{}
+		for _, obj := range objects {
+			etags[obj.Path] = obj.ETag
+		}
+		return etags
+	}
+	before := snapshot()
+
+	m.mustPull()
+	if got := m.files(); got["init.lua"] != "legacy\n" {
+		t.Fatalf("pulled files = %v, want the legacy init.lua", got)
+	}
+	if err := m.cfg().Status(nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.cfg().Diff("nvim", 0, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.cfg().Log("nvim"); err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshot(); !maps.Equal(after, before) {
+		t.Fatalf("read-only commands changed the remote from %v to %v", before, after)
+	}
+
+	m.write(map[string]string{"init.lua": "pushed\n"})
+	m.mustPush()
+	manifest, err := remote.ReadObject("oss://team/donk/cfg/nvim/.donk-manifest.json")
+	if err != nil {
+		t.Fatalf("push did not migrate the entry into its own manifest: %v", err)
+	}
+	if !strings.Contains(string(manifest), `"revision": 4`) {
+		t.Fatalf("migrated manifest =\n%s\nwant revision 4", manifest)
+	}
+	if legacy, err := remote.ReadObject("oss://team/donk/cfg/manifest.json"); err != nil || string(legacy) != string(content) {
+		t.Fatalf("legacy manifest = %q, %v, want it left for older versions", legacy, err)
+	}
+	if got := remoteFiles(t, remote); got["init.lua"] != "pushed\n" {
+		t.Fatalf("remote files = %v, want the pushed init.lua", got)
+	}
+}
+
+func TestCfgLog(t *testing.T) {
+	remote := newMemBackend(BackendOptions{})
+	m := newTestMachine(t, remote)
+	out, err := captureStdout(t, func() error { return m.cfg().Log("nvim") })
+	if err != nil || out != "no revision history exists on the remote for: nvim\n" {
+		t.Fatalf("Log() without revisions printed %q, %v", out, err)
+	}
+
+	m.write(map[string]string{"init.lua": "one\n"})
+	m.mustPush()
+	m.write(map[string]string{"init.lua": "two\n", "extra.lua": "extra\n"})
+	m.mustPush()
+	out, err = captureStdout(t, func() error { return m.cfg().Log("nvim") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Log() printed %d lines, want a header and two revisions:\n%s", len(lines), out)
+	}
+	want := [][]string{
+		{"REVISION", "UPDATED", "AT", "UPDATED", "BY", "FILES"},
+		{"2", "*", "*", "2", "(current)"},
+		{"1", "*", "*", "1"},
+	}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != len(want[i]) {
+			t.Fatalf("line %d = %q, want fields %v", i, line, want[i])
+		}
+		for j, field := range want[i] {
+			if field != "*" && fields[j] != field {
+				t.Fatalf("line %d = %q, want fields %v", i, line, want[i])
+			}
+		}
+	}
+}
+
+func TestCfgCheckout(t *testing.T) {
+	first := map[string]string{"init.lua": "a\nb\nc\n"}
+	second := map[string]string{"init.lua": "a\nB\nc\n", "extra.lua": "extra\n"}
+	tests := []struct {
+		name         string
+		setup        func(author *testMachine, m *testMachine)
+		revision     int64
+		push         bool
+		wantErr      string
+		wantFiles    map[string]string
+		wantRevision int64
+		wantBackup   map[string]string
+	}{
+		{
+			name:         "old revision",
+			setup:        func(author *testMachine, m *testMachine) { m.mustPull() },
+			revision:     1,
+			wantFiles:    first,
+			wantRevision: 2,
+		},
+		{
+			name:         "old revision pushed",
+			setup:        func(author *testMachine, m *testMachine) { m.mustPull() },
+			revision:     1,
+			push:         true,
+			wantFiles:    first,
+			wantRevision: 3,
+		},
+		{
+			name:     "missing revision",
+			setup:    func(author *testMachine, m *testMachine) { m.mustPull() },
+			revision: 9,
+			wantErr:  "configuration revision was not found on the remote. Name: nvim. Revision: 9",
+		},
+		{
+			name: "local changes",
+			setup: func(author *testMachine, m *testMachine) {
+				m.mustPull()
+				m.write(map[string]string{"init.lua": "local\n"})
+			},
+			revision: 1,
+			wantErr:  "the local directory has changes that were not pushed",
+		},
+		{
+			name: "pending merge",
+			setup: func(author *testMachine, m *testMachine) {
+				m.mustPull()
+				author.write(map[string]string{"init.lua": "a\nR\nc\n"})
+				author.mustPush()
+				m.write(map[string]string{"init.lua": "a\nL\nc\n"})
+				if err := m.pull(CfgStrategyNone); err == nil {
+					t.Fatal("pull with conflicts succeeded")
+				}
+			},
+			revision: 1,
+			wantErr:  "configuration checkout cannot continue because a merge has unresolved conflicts in: init.lua",
+		},
+		{
+			name: "local files that were never synced",
+			setup: func(author *testMachine, m *testMachine) {
+				m.write(map[string]string{"init.lua": "mine\n"})
+			},
+			revision:     1,
+			wantFiles:    first,
+			wantRevision: 2,
+			wantBackup:   map[string]string{"init.lua": "mine\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			author, m := newTestMachine(t, remote), newTestMachine(t, remote)
+			author.write(first)
+			author.mustPush()
+			author.write(second)
+			author.mustPush()
+			tt.setup(author, m)
+			before := m.files()
+
+			checkTestErr(t, m.cfg().Checkout("nvim", tt.revision, tt.push), tt.wantErr)
+			if tt.wantErr != "" {
+				if got := m.files(); !maps.Equal(got, before) {
+					t.Fatalf("failed checkout changed the local files from %v to %v", before, got)
+				}
+				return
+			}
+			if got := m.files(); !maps.Equal(got, tt.wantFiles) {
+				t.Fatalf("local files = %v, want %v", got, tt.wantFiles)
+			}
+			if got := m.remoteRevision(); got != tt.wantRevision {
+				t.Fatalf("remote revision = %d, want %d", got, tt.wantRevision)
+			}
+			if tt.push {
+				if got := remoteFiles(t, remote); !maps.Equal(got, tt.wantFiles) {
+					t.Fatalf("remote files = %v, want %v", got, tt.wantFiles)
+				}
+			} else {
+				// The checked out files are pushed on top of the latest revision.
+				m.mustPush()
+				if got := m.remoteRevision(); got != tt.wantRevision+1 {
+					t.Fatalf("remote revision after push = %d, want %d", got, tt.wantRevision+1)
+				}
+			}
+
+			backups, _ := filepath.Glob(filepath.Join(m.context.Dir, "backups", "cfg", "nvim", "*"))
+			if tt.wantBackup == nil {
+				if len(backups) > 0 {
+					t.Fatalf("checkout left backups %v", backups)
+				}
+				return
+			}
+			if len(backups) != 1 {
+				t.Fatalf("backups = %v, want one", backups)
+			}
+			if got := readTestDir(t, backups[0]); !maps.Equal(got, tt.wantBackup) {
+				t.Fatalf("backup = %v, want %v", got, tt.wantBackup)
+			}
+		})
+	}
+}
+
+func TestCfgStatus(t *testing.T) {
+	base := map[string]string{"init.lua": "a\n", "old.lua": "old\n"}
+	tests := []struct {
+		name     string
+		setup    func(other *testMachine, local *testMachine)
+		want     CfgStatus
+		wantText string
+	}{
+		{
+			name:     "not initialized",
+			setup:    func(other *testMachine, local *testMachine) {},
+			want:     CfgStatus{State: cfgStatusNotInitialized},
+			wantText: "nvim: not-initialized. Local revision: 0. Remote revision: 0\n",
+		},
+		{
+			name: "in sync",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+				local.mustPush()
+			},
+			want:     CfgStatus{State: cfgStatusInSync, LocalRevision: 1, RemoteRevision: 1},
+			wantText: "nvim: in-sync. Local revision: 1. Remote revision: 1\n",
+		},
+		{
+			name: "local modified",
+			setup: func(other *testMachine, local *testMachine) {
+				local.write(base)
+				local.mustPush()
+				local.write(map[string]string{"init.lua": "A\n", "new.lua": "new\n", "old.lua": ""})
+			},
+			want: CfgStatus{
+				State: cfgStatusLocalModified, LocalRevision: 1, RemoteRevision: 1,
+				Local: CfgFileChanges{Added: []string{"new.lua"}, Modified: []string{"init.lua"}, Deleted: []string{"old.lua"}},
+			},
+			wantText: "nvim: local-modified. Local revision: 1. Remote revision: 1\n" +
+				"  local added:    new.lua\n  local modified: init.lua\n  local deleted:  old.lua\n",
+		},
+		{
+			name: "remote ahead",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"init.lua": "R\n"})
+				other.mustPush()
+			},
+			want: CfgStatus{
+				State: cfgStatusRemoteAhead, LocalRevision: 1, RemoteRevision: 2,
+				Remote: CfgFileChanges{Modified: []string{"init.lua"}},
+			},
+			wantText: "nvim: remote-ahead. Local revision: 1. Remote revision: 2\n  remote modified: init.lua\n",
+		},
+		{
+			name: "diverged",
+			setup: func(other *testMachine, local *testMachine) {
+				other.write(base)
+				other.mustPush()
+				local.mustPull()
+				other.write(map[string]string{"old.lua": ""})
+				other.mustPush()
+				local.write(map[string]string{"init.lua": "L\n"})
+			},
+			want: CfgStatus{
+				State: cfgStatusDiverged, LocalRevision: 1, RemoteRevision: 2,
+				Local:  CfgFileChanges{Modified: []string{"init.lua"}},
+				Remote: CfgFileChanges{Deleted: []string{"old.lua"}},
+			},
+			wantText: "nvim: diverged. Local revision: 1. Remote revision: 2\n" +
+				"  local modified: init.lua\n  remote deleted:  old.lua\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			other, local := newTestMachine(t, remote), newTestMachine(t, remote)
+			tt.setup(other, local)
+
+			out, err := captureStdout(t, func() error { return local.cfg().Status(nil, false) })
+			if err != nil || out != tt.wantText {
+				t.Fatalf("Status() printed\n%s%v\nwant\n%s", out, err, tt.wantText)
+			}
+
+			out, err = captureStdout(t, func() error { return local.cfg().Status([]string{"nvim"}, true) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			var statuses []CfgStatus
+			if err := json.Unmarshal([]byte(out), &statuses); err != nil {
+				t.Fatalf("Status() --json printed invalid JSON: %v\n%s", err, out)
+			}
+			want := tt.want
+			want.Name = "nvim"
+			for _, changes := range []*CfgFileChanges{&want.Local, &want.Remote} {
+				for _, paths := range []*[]string{&changes.Added, &changes.Modified, &changes.Deleted} {
+					if *paths == nil {
+						*paths = []string{}
+					}
+				}
+			}
+			if len(statuses) != 1 || !reflect.DeepEqual(statuses[0], want) {
+				t.Fatalf("Status() --json = %+v, want %+v", statuses, want)
+			}
+		})
+	}
+}
+
+func TestCfgDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		revision int64
+		stat     bool
+		nameOnly bool
+		wantErr  string
+		want     string
+	}{
+		{
+			name: "unified",
+			want: "--- a/init.lua\n+++ b/init.lua\n@@ -1,3 +1,4 @@\n a\n B\n c\n+d\n" +
+				"Binary files a/logo.png and b/logo.png differ\n" +
+				"--- /dev/null\n+++ b/new.lua\n@@ -0,0 +1 @@\n+new\n" +
+				"--- a/old.lua\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+		},
+		{
+			name:     "older revision",
+			revision: 1,
+			want: "--- a/init.lua\n+++ b/init.lua\n@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n" +
+				"Binary files a/logo.png and b/logo.png differ\n" +
+				"--- /dev/null\n+++ b/new.lua\n@@ -0,0 +1 @@\n+new\n" +
+				"--- a/old.lua\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+		},
+		{
+			name: "stat",
+			stat: true,
+			want: " init.lua | 1 +\n logo.png | Bin 6 -> 7 bytes\n new.lua  | 1 +\n old.lua  | 1 -\n" +
+				" 4 files changed, 2 insertions(+), 1 deletions(-)\n",
+		},
+		{
+			name:     "older revision stat",
+			revision: 1,
+			stat:     true,
+			want: " init.lua | 3 ++-\n logo.png | Bin 6 -> 7 bytes\n new.lua  | 1 +\n old.lua  | 1 -\n" +
+				" 4 files changed, 3 insertions(+), 2 deletions(-)\n",
+		},
+		{
+			name:     "name only",
+			nameOnly: true,
+			want:     "init.lua\nlogo.png\nnew.lua\nold.lua\n",
+		},
+		{
+			name:     "missing revision",
+			revision: 5,
+			wantErr:  "configuration revision was not found on the remote. Name: nvim. Revision: 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			author, m := newTestMachine(t, remote), newTestMachine(t, remote)
+			author.write(map[string]string{"init.lua": "a\nb\nc\n", "logo.png": "\x89PNG\x00\x01", "old.lua": "old\n"})
+			author.mustPush()
+			author.write(map[string]string{"init.lua": "a\nB\nc\n"})
+			author.mustPush()
+			m.mustPull()
+			m.write(map[string]string{"init.lua": "a\nB\nc\nd\n", "logo.png": "\x89PNG\x00\x02\x03", "new.lua": "new\n", "old.lua": ""})
+
+			out, err := captureStdout(t, func() error { return m.cfg().Diff("nvim", tt.revision, tt.stat, tt.nameOnly) })
+			checkTestErr(t, err, tt.wantErr)
+			if out != tt.want {
+				t.Fatalf("Diff() printed\n%s\nwant\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestCfgResolve(t *testing.T) {
+	conflicted := map[string]string{"init.lua": "a\n<<<<<<< local\nL\n=======\nR\n>>>>>>> remote\nc\n", "bin.dat": "\x00local", "bin.dat.donk-remote": "\x00remote"}
+	tests := []struct {
+		name        string
+		fix         map[string]string
+		run         func(m *testMachine) error
+		wantErr     string
+		wantLocal   map[string]string
+		wantRemote  map[string]string
+		wantPending bool
+	}{
+		{
+			name:        "conflict markers remain",
+			run:         func(m *testMachine) error { return m.cfg().Resolve("nvim") },
+			wantErr:     "configuration resolve failed because conflict markers remain in: init.lua",
+			wantLocal:   conflicted,
+			wantRemote:  map[string]string{"init.lua": "a\nR\nc\n", "bin.dat": "\x00remote"},
+			wantPending: true,
+		},
+		{
+			name:       "fixed by hand",
+			fix:        map[string]string{"init.lua": "a\nL\nR\nc\n"},
+			run:        func(m *testMachine) error { return m.cfg().Resolve("nvim") },
+			wantLocal:  map[string]string{"init.lua": "a\nL\nR\nc\n", "bin.dat": "\x00local"},
+			wantRemote: map[string]string{"init.lua": "a\nL\nR\nc\n", "bin.dat": "\x00local"},
+		},
+		{
+			name:       "push settles for the local side",
+			run:        func(m *testMachine) error { return m.push(CfgStrategyPreferLocal) },
+			wantLocal:  map[string]string{"init.lua": "a\nL\nc\n", "bin.dat": "\x00local"},
+			wantRemote: map[string]string{"init.lua": "a\nL\nc\n", "bin.dat": "\x00local"},
+		},
+		{
+			name:       "pull settles for the remote side",
+			run:        func(m *testMachine) error { return m.pull(CfgStrategyPreferRemote) },
+			wantLocal:  map[string]string{"init.lua": "a\nR\nc\n", "bin.dat": "\x00remote"},
+			wantRemote: map[string]string{"init.lua": "a\nR\nc\n", "bin.dat": "\x00remote"},
+		},
+		{
+			name:       "settling keeps files fixed by hand",
+			fix:        map[string]string{"init.lua": "a\nL\nR\nc\n"},
+			run:        func(m *testMachine) error { return m.push(CfgStrategyPreferRemote) },
+			wantLocal:  map[string]string{"init.lua": "a\nL\nR\nc\n", "bin.dat": "\x00remote"},
+			wantRemote: map[string]string{"init.lua": "a\nL\nR\nc\n", "bin.dat": "\x00remote"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			other, local := newTestMachine(t, remote), newTestMachine(t, remote)
+			other.write(map[string]string{"init.lua": "a\nb\nc\n", "bin.dat": "\x00base"})
+			other.mustPush()
+			local.mustPull()
+			other.write(map[string]string{"init.lua": "a\nR\nc\n", "bin.dat": "\x00remote"})
+			other.mustPush()
+			local.write(map[string]string{"init.lua": "a\nL\nc\n", "bin.dat": "\x00local"})
+			checkTestErr(t, local.pull(CfgStrategyNone), "found conflicts in: bin.dat, init.lua")
+			if got := local.files(); !maps.Equal(got, conflicted) {
+				t.Fatalf("local files after the merge = %q, want %q", got, conflicted)
+			}
+			local.write(tt.fix)
+
+			checkTestErr(t, tt.run(local), tt.wantErr)
+			if got := local.files(); !maps.Equal(got, tt.wantLocal) {
+				t.Fatalf("local files = %q, want %q", got, tt.wantLocal)
+			}
+			if got := remoteFiles(t, remote); !maps.Equal(got, tt.wantRemote) {
+				t.Fatalf("remote files = %q, want %q", got, tt.wantRemote)
+			}
+			manifest, err := local.cfg().loadLocalCfgManifest(local.cfg().buildLocalCfgManifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, pending := manifest.Merges["nvim"]; pending != tt.wantPending {
+				t.Fatalf("merge pending = %v, want %v", pending, tt.wantPending)
+			}
+			if !tt.wantPending {
+				// Nothing stops the next sync any more.
+				local.mustPull()
+			}
+		})
+	}
+
+	m := newTestMachine(t, newMemBackend(BackendOptions{}))
+	checkTestErr(t, m.cfg().Resolve("nvim"), "no merge with conflicts is in progress for: nvim")
+}
+
+func TestCfgInit(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(m *testMachine)
+		dryRun  bool
+		wantErr string
+	}{
+		{
+			name:  "directory",
+			setup: func(m *testMachine) { writeTestFiles(m.t, m.link, map[string]string{"init.lua": "init\n"}) },
+		},
+		{
+			name:    "missing link path",
+			setup:   func(m *testMachine) {},
+			wantErr: "the configured link path does not exist",
+		},
+		{
+			name: "already initialized",
+			setup: func(m *testMachine) {
+				writeTestFiles(m.t, m.link, map[string]string{"init.lua": "init\n"})
+				if err := m.cfg().Init("nvim"); err != nil {
+					m.t.Fatal(err)
+				}
+			},
+			wantErr: "the link path is already initialized",
+		},
+		{
+			name: "symbolic link elsewhere",
+			setup: func(m *testMachine) {
+				if err := os.Symlink(m.t.TempDir(), m.link); err != nil {
+					m.t.Fatal(err)
+				}
+			},
+			wantErr: "the configured link path is an unexpected symbolic link",
+		},
+		{
+			name: "file",
+			setup: func(m *testMachine) {
+				if err := os.WriteFile(m.link, []byte("init\n"), 0o644); err != nil {
+					m.t.Fatal(err)
+				}
+			},
+			wantErr: "the configured link path is not a directory",
+		},
+		{
+			name: "local cfg directory exists",
+			setup: func(m *testMachine) {
+				writeTestFiles(m.t, m.link, map[string]string{"init.lua": "init\n"})
+				m.write(map[string]string{"init.lua": "other\n"})
+			},
+			wantErr: "the local cfg directory already exists",
+		},
+		{
+			name:   "dry run",
+			setup:  func(m *testMachine) { writeTestFiles(m.t, m.link, map[string]string{"init.lua": "init\n"}) },
+			dryRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			m := newTestMachine(t, remote)
+			tt.setup(m)
+			m.context.DryRun = tt.dryRun
+			checkTestErr(t, m.cfg().Init("nvim"), tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+
+			want := map[string]string{"init.lua": "init\n"}
+			info, err := os.Lstat(m.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.dryRun {
+				if !info.IsDir() || m.remoteRevision() != 0 || len(m.files()) != 0 {
+					t.Fatal("dry run changed the link path, the local cfg directory or the remote")
+				}
+				return
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("link path %s is not a symbolic link", m.link)
+			}
+			if got := readTestDir(t, m.link+"/"); !maps.Equal(got, want) {
+				t.Fatalf("files behind the link = %v, want %v", got, want)
+			}
+			if m.remoteRevision() != 1 {
+				t.Fatalf("remote revision = %d, want 1", m.remoteRevision())
+			}
+		})
+	}
+}
+
+// unconditionalBackend hides every optional interface of the wrapped backend,
+// like a remote that has no conditional writes.
+func TestCfgSaveRemoteEntryPushedConcurrently(t *testing.T) {
+	remote := newMemBackend(BackendOptions{})
+	m, other := newTestMachine(t, remote), newTestMachine(t, remote)
+	m.write(map[string]string{"init.lua": "a\n"})
+	m.mustPush()
+	dst, err := m.cfg().buildRemoteEntryManifestPath(m.entry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := remote.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.mustPull()
+	other.write(map[string]string{"init.lua": "b\n"})
+	other.mustPush()
+
+	remoteEntry, _, _, err := m.cfg().loadRemoteCfgEntry(m.entry(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.cfg().saveRemoteCfgEntry(m.entry(), remoteEntry, stale.ETag, nil)
+	if !errors.Is(err, errCfgPushedConcurrently) {
+		t.Fatalf("saveRemoteCfgEntry() with a stale etag = %v, want %v", err, errCfgPushedConcurrently)
+	}
+	checkTestErr(t, err, "Please run donk cfg pull nvim and push again")
+}
+
+type unconditionalBackend struct {
+	Backend
+}
+
+func TestConcurrentCfgPushWithoutConditionalWrites(t *testing.T) {
+	for attempt := 0; attempt < 20; attempt++ {
+		root := t.TempDir()
+		fileBackend, err := NewFileBackend(OSSConfig{}, BackendOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings := Settings{
+			OSS: OSSConfig{Name: "file", Bucket: root},
+			Cfg: []ConfigEntry{{Name: "nvim", OSS: "file://" + root + "/donk/cfg/nvim"}},
+		}
+		homes := []string{t.TempDir(), t.TempDir()}
+		for i, home := range homes {
+			writeTestFiles(t, filepath.Join(home, "cfg", "nvim"), map[string]string{"init.lua": string(rune('a' + i))})
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(homes))
+		for i, home := range homes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c := Context{Dir: home, Settings: settings, Backends: map[string]Backend{"file": unconditionalBackend{fileBackend}}}
+				errs[i] = CreateCfgCmd(c).Push("nvim", CfgStrategyNone)
+			}()
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+			}
+		}
+		if succeeded != 1 {
+			t.Fatalf("attempt %d: %d pushes succeeded, want exactly one. Errors: %v", attempt, succeeded, errs)
+		}
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cfgDiffFile is one path whose remote and local content differ. A nil
+// content means the file does not exist on that side.
+type cfgDiffFile struct {
+	path   string
+	remote []byte
+	local  []byte
+}
+
+// Diff prints how the local files of an entry differ from the current remote
+// revision, or from an older revision when revision is positive. Only files
+// whose manifest hashes differ are downloaded.
+func (c CfgCmd) Diff(name string, revision int64, stat bool, nameOnly bool) error {
+	entry, err := findEntry(c.Context.Settings.Cfg, name)
+	if err != nil {
+		return err
+	}
+
+	var remoteEntry CfgManifestEntry
+	legacySrc := entry.OSS
+	if revision > 0 {
+		if remoteEntry, err = c.loadRemoteCfgRevision(entry, revision); err != nil {
+			return err
+		}
+		if legacySrc, err = c.buildRemoteCfgRevisionPath(entry, revision); err != nil {
+			return err
+		}
+	} else {
+		var exists bool
+		remoteEntry, exists, _, err = c.loadRemoteCfgEntry(entry, false)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("configuration diff failed because no remote manifest entry exists for: %s", name)
+		}
+	}
+
+	localCfgDir := c.buildLocalCfgDir(name)
+	localFiles, err := c.buildCfgFileSnapshot(localCfgDir)
+	if err != nil {
+		return err
+	}
+	changes := c.diffCfgFiles(remoteEntry.Files, localFiles)
+	if changes.isEmpty() {
+		return nil
+	}
+
+	if nameOnly {
+		for _, path := range c.cfgChangedPaths(changes) {
+			fmt.Println(path)
+		}
+		return nil
+	}
+
+	files, err := c.loadCfgDiffFiles(entry, remoteEntry, legacySrc, localCfgDir, changes)
+	if err != nil {
+		return err
+	}
+	finishProgress(c.Context.Progress)
+	if stat {
+		c.printCfgDiffStat(files)
+		return nil
+	}
+	for _, file := range files {
+		fmt.Print(c.formatCfgFileDiff(file))
+	}
+	return nil
+}
+
+func (c CfgCmd) cfgChangedPaths(changes cfgFileChanges) []string {
+	paths := make([]string, 0)
+	for _, group := range [][]CfgManifestFile{changes.added, changes.changed, changes.removed} {
+		for _, file := range group {
+			paths = append(paths, file.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// loadCfgDiffFiles reads both sides of every changed path. Remote content of
+// blob entries comes through the local blob cache, so files that are cached
+// already need no download.
+func (c CfgCmd) loadCfgDiffFiles(entry ConfigEntry, remoteEntry CfgManifestEntry, legacySrc string, localCfgDir string, changes cfgFileChanges) ([]cfgDiffFile, error) {
+	remoteByPath := map[string]CfgManifestFile{}
+	for _, file := range remoteEntry.Files {
+		remoteByPath[file.Path] = file
+	}
+	paths := c.cfgChangedPaths(changes)
+
+	var store blobStore
+	var backend Backend
+	var err error
+	if remoteEntry.Storage == cfgStorageBlobs {
+		if store, err = newBlobStore(c.Context, entry, remoteEntry.Encrypted); err != nil {
+			return nil, err
+		}
+		hashes := make([]string, 0)
+		for _, path := range paths {
+			if file, ok := remoteByPath[path]; ok {
+				hashes = append(hashes, file.SHA256)
+			}
+		}
+		if err := store.fetchAll(hashes); err != nil {
+			return nil, err
+		}
+	} else if backend, err = c.Context.openBackend(legacySrc); err != nil {
+		return nil, err
+	}
+
+	files := make([]cfgDiffFile, 0, len(paths))
+	for _, path := range paths {
+		file := cfgDiffFile{path: path}
+		if remote, ok := remoteByPath[path]; ok {
+			if remoteEntry.Storage == cfgStorageBlobs {
+				file.remote, err = os.ReadFile(store.localPath(remote.SHA256))
+			} else {
+				file.remote, err = backend.ReadObject(joinRemotePath(legacySrc, path))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("configuration diff failed while reading the remote file %s: %w", path, err)
+			}
+			if file.remote == nil {
+				file.remote = []byte{}
+			}
+		}
+		local, err := os.ReadFile(filepath.Join(localCfgDir, filepath.FromSlash(path)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			file.local = local
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (c CfgCmd) formatCfgFileDiff(file cfgDiffFile) string {
+	oldName, newName := "a/"+file.path, "b/"+file.path
+	if file.remote == nil {
+		oldName = "/dev/null"
+	}
+	if file.local == nil {
+		newName = "/dev/null"
+	}
+	if isBinaryContent(file.remote) || isBinaryContent(file.local) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	}
+	return unifiedDiff(oldName, newName, diffLines(splitLines(file.remote), splitLines(file.local)))
+}
+
+// printCfgDiffStat prints one line per file with its inserted and deleted
+// line counts, followed by the totals, similar to git diff --stat.
+func (c CfgCmd) printCfgDiffStat(files []cfgDiffFile) {
+	const barWidth = 40
+	width := 0
+	for _, file := range files {
+		width = max(width, len(file.path))
+	}
+	totalInsertions, totalDeletions := 0, 0
+	for _, file := range files {
+		if isBinaryContent(file.remote) || isBinaryContent(file.local) {
+			fmt.Printf(" %-*s | Bin %d -> %d bytes\n", width, file.path, len(file.remote), len(file.local))
+			continue
+		}
+		insertions, deletions := countDiffLines(diffLines(splitLines(file.remote), splitLines(file.local)))
+		totalInsertions += insertions
+		totalDeletions += deletions
+		plus, minus := insertions, deletions
+		if total := insertions + deletions; total > barWidth {
+			plus = insertions * barWidth / total
+			minus = barWidth - plus
+		}
+		fmt.Printf(" %-*s | %d %s%s\n", width, file.path, insertions+deletions, strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	fmt.Printf(" %d files changed, %d insertions(+), %d deletions(-)\n", len(files), totalInsertions, totalDeletions)
+}
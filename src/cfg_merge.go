@@ -0,0 +1,496 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CfgStrategy decides which side wins when cfg pull or push would otherwise
+// stop because the local and remote content disagree.
+type CfgStrategy int
+
+const (
+	CfgStrategyNone CfgStrategy = iota
+	// CfgStrategyForce replaces the other side outright. Pull overwrites the
+	// local files, and push publishes them without merging.
+	CfgStrategyForce
+	// CfgStrategyPreferLocal and CfgStrategyPreferRemote merge as usual and
+	// settle every conflict in favor of one side.
+	CfgStrategyPreferLocal
+	CfgStrategyPreferRemote
+)
+
+// cfgBackupTimeLayout names backup directories. It sorts by time and has no
+// characters that are awkward in object keys.
+const cfgBackupTimeLayout = "20060102T150405.000Z"
+
+const cfgBackupsDirName = reservedNamePrefix + "backups"
+
+func (s CfgStrategy) resolution(push bool) mergeResolution {
+	switch {
+	case s == CfgStrategyPreferLocal || (s == CfgStrategyForce && push):
+		return mergeTakeLocal
+	case s == CfgStrategyPreferRemote || s == CfgStrategyForce:
+		return mergeTakeRemote
+	}
+	return mergeMarkConflicts
+}
+
+func parseCfgStrategy(parsed cmdArgs) (CfgStrategy, error) {
+	strategy := CfgStrategyNone
+	for _, flag := range []struct {
+		name     string
+		strategy CfgStrategy
+	}{{"force", CfgStrategyForce}, {"prefer-local", CfgStrategyPreferLocal}, {"prefer-remote", CfgStrategyPreferRemote}} {
+		if !parsed.has(flag.name) {
+			continue
+		}
+		if strategy != CfgStrategyNone {
+			return CfgStrategyNone, errors.New("only one of --force, --prefer-local and --prefer-remote can be given")
+		}
+		strategy = flag.strategy
+	}
+	return strategy, nil
+}
+
+// cfgRemoteSidecarSuffix names the file a conflicting remote version is
+// written to when it cannot be merged line by line. Sidecars are never pushed.
+const cfgRemoteSidecarSuffix = reservedNamePrefix + "remote"
+
+// CfgMergeState is kept in the local manifest while a merge has conflicts that
+// were not resolved yet. Remote is the revision that was merged in.
+type CfgMergeState struct {
+	Remote    CfgManifestEntry `json:"remote"`
+	Conflicts []string         `json:"conflicts"`
+}
+
+type cfgMergeResult struct {
+	updated   []string
+	merged    []string
+	settled   []string
+	conflicts []string
+}
+
+func (r cfgMergeResult) String() string {
+	line := fmt.Sprintf("Updated: %d. Merged: %d. Conflicts: %d", len(r.updated), len(r.merged), len(r.conflicts))
+	if len(r.settled) > 0 {
+		line += fmt.Sprintf(". Settled: %d", len(r.settled))
+	}
+	return line
+}
+
+// mergeCfgEntry merges the changes of a remote revision into localCfgDir. Base
+// is the local manifest entry, which holds the files of the revision the local
+// directory was last synced at. Files changed on one side only take that side.
+// Text files changed on both sides are merged line by line, and conflicting
+// regions are written between conflict markers. Other conflicts keep the local
+// file and write the remote one next to it with cfgRemoteSidecarSuffix. When
+// resolution picks a side, conflicts are settled for that side instead.
+func (c CfgCmd) mergeCfgEntry(entry ConfigEntry, base CfgManifestEntry, remote CfgManifestEntry, localCfgDir string, resolution mergeResolution) (cfgMergeResult, error) {
+	if remote.Storage != cfgStorageBlobs {
+		return cfgMergeResult{}, fmt.Errorf("configuration merge failed because the remote revision was pushed by an older version that does not store blobs. Revision: %d. Name: %s", remote.Revision, entry.Name)
+	}
+	localFiles, err := c.buildCfgFileSnapshot(localCfgDir)
+	if err != nil {
+		return cfgMergeResult{}, err
+	}
+	baseByPath, localByPath, remoteByPath := cfgHashesByPath(base.Files), cfgHashesByPath(localFiles), cfgHashesByPath(remote.Files)
+	takeRemote, bothChanged := c.classifyCfgMerge(base.Files, localFiles, remote.Files)
+
+	remoteHashes, baseHashes := make([]string, 0), make([]string, 0)
+	for _, path := range append(append([]string(nil), takeRemote...), bothChanged...) {
+		if !isWithinDir(localCfgDir, filepath.Join(localCfgDir, filepath.FromSlash(path))) {
+			return cfgMergeResult{}, fmt.Errorf("configuration merge failed because a manifest path escapes the cfg directory: %s", path)
+		}
+		if hash := remoteByPath[path]; hash != "" {
+			remoteHashes = append(remoteHashes, hash)
+		}
+	}
+	for _, path := range bothChanged {
+		if hash := baseByPath[path]; hash != "" {
+			baseHashes = append(baseHashes, hash)
+		}
+	}
+
+	store, err := newBlobStore(c.Context, entry, remote.Encrypted)
+	if err != nil {
+		return cfgMergeResult{}, err
+	}
+	if err := store.fetchAll(remoteHashes); err != nil {
+		return cfgMergeResult{}, err
+	}
+	baseContents, err := c.loadCfgMergeBases(entry, base, baseHashes)
+	if err != nil {
+		return cfgMergeResult{}, err
+	}
+
+	result := cfgMergeResult{}
+	for _, path := range takeRemote {
+		target := filepath.Join(localCfgDir, filepath.FromSlash(path))
+		if hash := remoteByPath[path]; hash != "" {
+			if err := copyFile(store.localPath(hash), target); err != nil {
+				return cfgMergeResult{}, err
+			}
+		} else if err := c.removeCfgFile(localCfgDir, target); err != nil {
+			return cfgMergeResult{}, err
+		}
+		result.updated = append(result.updated, path)
+	}
+
+	for _, path := range bothChanged {
+		target := filepath.Join(localCfgDir, filepath.FromSlash(path))
+		var remoteContent []byte
+		if hash := remoteByPath[path]; hash != "" {
+			if remoteContent, err = os.ReadFile(store.localPath(hash)); err != nil {
+				return cfgMergeResult{}, err
+			}
+		}
+		baseContent, baseKnown := baseContents[baseByPath[path]]
+		if baseByPath[path] == "" {
+			baseContent, baseKnown = []byte{}, true
+		}
+
+		if localByPath[path] != "" && remoteContent != nil && baseKnown {
+			localContent, err := os.ReadFile(target)
+			if err != nil {
+				return cfgMergeResult{}, err
+			}
+			if !isBinaryContent(baseContent) && !isBinaryContent(localContent) && !isBinaryContent(remoteContent) {
+				lines, conflicts := mergeLines(splitLines(baseContent), splitLines(localContent), splitLines(remoteContent), resolution)
+				info, err := os.Stat(target)
+				if err != nil {
+					return cfgMergeResult{}, err
+				}
+				if err := os.WriteFile(target, []byte(strings.Join(lines, "")), info.Mode().Perm()); err != nil {
+					return cfgMergeResult{}, err
+				}
+				switch {
+				case conflicts > 0 && resolution != mergeMarkConflicts:
+					result.settled = append(result.settled, path)
+				case conflicts > 0:
+					result.conflicts = append(result.conflicts, path)
+				default:
+					result.merged = append(result.merged, path)
+				}
+				continue
+			}
+		}
+
+		switch resolution {
+		case mergeTakeLocal:
+		case mergeTakeRemote:
+			if remoteContent != nil {
+				err = copyFile(store.localPath(remoteByPath[path]), target)
+			} else {
+				err = c.removeCfgFile(localCfgDir, target)
+			}
+			if err != nil {
+				return cfgMergeResult{}, err
+			}
+		default:
+			// The local side wins for now. A remote deletion leaves no
+			// sidecar, so resolving keeps or deletes the local file.
+			if remoteContent != nil {
+				if err := copyFile(store.localPath(remoteByPath[path]), target+cfgRemoteSidecarSuffix); err != nil {
+					return cfgMergeResult{}, err
+				}
+			}
+			result.conflicts = append(result.conflicts, path)
+			continue
+		}
+		result.settled = append(result.settled, path)
+	}
+	return result, nil
+}
+
+// classifyCfgMerge returns the paths a merge has to touch. Only files changed
+// on the remote side need any work: takeRemote were changed on the remote
+// side only, bothChanged on both sides in different ways.
+func (c CfgCmd) classifyCfgMerge(base []CfgManifestFile, local []CfgManifestFile, remote []CfgManifestFile) ([]string, []string) {
+	baseByPath, localByPath, remoteByPath := cfgHashesByPath(base), cfgHashesByPath(local), cfgHashesByPath(remote)
+	paths := make([]string, 0)
+	seen := map[string]bool{}
+	for _, files := range []map[string]string{baseByPath, localByPath, remoteByPath} {
+		for path := range files {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	takeRemote, bothChanged := make([]string, 0), make([]string, 0)
+	for _, path := range paths {
+		baseHash, localHash, remoteHash := baseByPath[path], localByPath[path], remoteByPath[path]
+		switch {
+		case localHash == remoteHash || remoteHash == baseHash:
+		case localHash == baseHash:
+			takeRemote = append(takeRemote, path)
+		default:
+			bothChanged = append(bothChanged, path)
+		}
+	}
+	return takeRemote, bothChanged
+}
+
+// planCfgMerge adds the steps of a merge to a dry run and returns the local
+// files as they would be afterwards. Whether files changed on both sides
+// conflict is only known once their contents are downloaded, so those keep
+// their local version.
+func (c CfgCmd) planCfgMerge(plan *dryRunPlan, base CfgManifestEntry, remote CfgManifestEntry, localCfgDir string) ([]CfgManifestFile, error) {
+	if remote.Storage != cfgStorageBlobs {
+		return nil, fmt.Errorf("configuration merge failed because the remote revision was pushed by an older version that does not store blobs. Revision: %d", remote.Revision)
+	}
+	localFiles, err := c.buildCfgFileSnapshot(localCfgDir)
+	if err != nil {
+		return nil, err
+	}
+	takeRemote, bothChanged := c.classifyCfgMerge(base.Files, localFiles, remote.Files)
+	remoteByPath := map[string]CfgManifestFile{}
+	for _, file := range remote.Files {
+		remoteByPath[file.Path] = file
+	}
+	filesByPath := map[string]CfgManifestFile{}
+	for _, file := range localFiles {
+		filesByPath[file.Path] = file
+	}
+	for _, path := range takeRemote {
+		if file, ok := remoteByPath[path]; ok {
+			plan.add("download", "%s (%s)", path, formatBytes(file.Size))
+			filesByPath[path] = file
+		} else {
+			plan.add("delete", "%s", path)
+			delete(filesByPath, path)
+		}
+	}
+	for _, path := range bothChanged {
+		plan.add("merge", "%s with remote revision %d", path, remote.Revision)
+	}
+
+	files := make([]CfgManifestFile, 0, len(filesByPath))
+	for _, file := range filesByPath {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// loadCfgMergeBases reads the base versions of files changed on both sides.
+// Blobs of the base revision normally are in the local cache already. Blobs
+// that cannot be found anywhere are left out, which turns their files into
+// sidecar conflicts.
+func (c CfgCmd) loadCfgMergeBases(entry ConfigEntry, base CfgManifestEntry, hashes []string) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	if len(hashes) == 0 || base.Storage != cfgStorageBlobs {
+		return contents, nil
+	}
+	store, err := newBlobStore(c.Context, entry, base.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		path, err := store.fetch(store.opts.ctx(), hash)
+		if err != nil {
+			if c.isRemoteNotFoundErr(err) {
+				continue
+			}
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		contents[hash] = content
+	}
+	return contents, nil
+}
+
+// recordCfgMerge makes remote the synced revision of an entry after a merge.
+// Conflicts are remembered until donk cfg resolve finishes the merge.
+func (c CfgCmd) recordCfgMerge(manifest *CfgManifest, name string, remote CfgManifestEntry, conflicts []string) error {
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]CfgManifestEntry{}
+	}
+	manifest.Entries[name] = remote
+	if len(conflicts) > 0 {
+		if manifest.Merges == nil {
+			manifest.Merges = map[string]CfgMergeState{}
+		}
+		manifest.Merges[name] = CfgMergeState{Remote: remote, Conflicts: conflicts}
+	}
+	return c.saveLocalCfgManifest(c.buildLocalCfgManifestPath(), *manifest)
+}
+
+// settleCfgMerge finishes a merge with conflicts in favor of one side. Files
+// with conflict markers keep the lines of that side, and files with a sidecar
+// keep the local file or take the sidecar. Files the user already fixed by
+// hand are left alone.
+func (c CfgCmd) settleCfgMerge(manifest *CfgManifest, name string, local bool, plan *dryRunPlan) error {
+	state := manifest.Merges[name]
+	if plan != nil {
+		side := "remote"
+		if local {
+			side = "local"
+		}
+		for _, path := range state.Conflicts {
+			plan.add("settle", "%s for the %s side", path, side)
+		}
+		delete(manifest.Merges, name)
+		return nil
+	}
+	remoteByPath := cfgHashesByPath(state.Remote.Files)
+	localCfgDir := c.buildLocalCfgDir(name)
+	for _, path := range state.Conflicts {
+		target := filepath.Join(localCfgDir, filepath.FromSlash(path))
+		sidecar := target + cfgRemoteSidecarSuffix
+		content, err := os.ReadFile(target)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		_, sidecarErr := os.Stat(sidecar)
+		switch {
+		case hasConflictMarkers(content):
+			info, err := os.Stat(target)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, keepMergeSide(content, local), info.Mode().Perm()); err != nil {
+				return err
+			}
+		case local:
+		case sidecarErr == nil:
+			if err := os.Rename(sidecar, target); err != nil {
+				return err
+			}
+		case remoteByPath[path] == "":
+			if err := c.removeCfgFile(localCfgDir, target); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	delete(manifest.Merges, name)
+	return c.saveLocalCfgManifest(c.buildLocalCfgManifestPath(), *manifest)
+}
+
+// backupLocalCfgDir copies the local files of an entry to a timestamped
+// directory under ~/.donk/backups/cfg before a strategy flag lets pull or push
+// replace any of them. Nothing is saved when the files match remote.
+func (c CfgCmd) backupLocalCfgDir(name string, remote CfgManifestEntry, plan *dryRunPlan) error {
+	localCfgDir := c.buildLocalCfgDir(name)
+	if _, err := os.Stat(localCfgDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	isEqual, err := c.isLocalCfgEqualToManifest(localCfgDir, remote)
+	if err != nil || isEqual {
+		return err
+	}
+	dst := filepath.Join(c.Context.Dir, "backups", "cfg", name, time.Now().UTC().Format(cfgBackupTimeLayout))
+	if plan != nil {
+		plan.add("backup", "local files to %s", dst)
+		return nil
+	}
+	if err := c.copyDirForCfgInit(localCfgDir, dst); err != nil {
+		return fmt.Errorf("configuration backup failed while copying the local files of %s: %w", name, err)
+	}
+	fmt.Printf("configuration backup of the local files saved to: %s\n", dst)
+	return nil
+}
+
+// backupRemoteCfgEntry saves the current remote manifest of an entry under
+// donk/cfg/<name>/.donk-backups before a push with a strategy flag replaces it.
+// Blobs are never deleted, so the manifest is enough to restore every file.
+func (c CfgCmd) backupRemoteCfgEntry(entry ConfigEntry, remote CfgManifestEntry, plan *dryRunPlan) error {
+	dst, err := c.buildRemoteCfgPath(entry, fmt.Sprintf("%s/%s/%s/%s", entry.Name, cfgBackupsDirName, time.Now().UTC().Format(cfgBackupTimeLayout), cfgEntryManifestName))
+	if err != nil {
+		return err
+	}
+	if plan != nil {
+		plan.add("backup", "remote revision %d to %s", remote.Revision, dst)
+		return nil
+	}
+	content, err := c.encodeRemoteCfgEntry(entry, remote)
+	if err != nil {
+		return err
+	}
+	backend, err := c.Context.openBackend(dst)
+	if err != nil {
+		return err
+	}
+	if err := backend.WriteObject(dst, content); err != nil {
+		return fmt.Errorf("configuration backup failed while saving remote revision %d: %w", remote.Revision, err)
+	}
+	fmt.Printf("configuration backup of remote revision %d saved to: %s\n", remote.Revision, dst)
+	return nil
+}
+
+func (c CfgCmd) checkNoPendingCfgMerge(manifest CfgManifest, name string, action string) error {
+	if state, ok := manifest.Merges[name]; ok {
+		return fmt.Errorf("configuration %s cannot continue because a merge has unresolved conflicts in: %s. Fix the files, then run donk cfg resolve %s", action, strings.Join(state.Conflicts, ", "), name)
+	}
+	return nil
+}
+
+// Resolve finishes a merge that had conflicts. Every conflict marker must be
+// gone; sidecar files are removed and the result is pushed as a new revision.
+func (c CfgCmd) Resolve(name string) error {
+	if _, err := findEntry(c.Context.Settings.Cfg, name); err != nil {
+		return err
+	}
+	localManifestPath := c.buildLocalCfgManifestPath()
+	localManifest, err := c.loadLocalCfgManifest(localManifestPath)
+	if err != nil {
+		return err
+	}
+	state, ok := localManifest.Merges[name]
+	if !ok {
+		return fmt.Errorf("configuration resolve failed because no merge with conflicts is in progress for: %s", name)
+	}
+
+	localCfgDir := c.buildLocalCfgDir(name)
+	unresolved := make([]string, 0)
+	for _, path := range state.Conflicts {
+		content, err := os.ReadFile(filepath.Join(localCfgDir, filepath.FromSlash(path)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if hasConflictMarkers(content) {
+			unresolved = append(unresolved, path)
+		}
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("configuration resolve failed because conflict markers remain in: %s", strings.Join(unresolved, ", "))
+	}
+
+	for _, path := range state.Conflicts {
+		sidecar := filepath.Join(localCfgDir, filepath.FromSlash(path)) + cfgRemoteSidecarSuffix
+		if err := os.Remove(sidecar); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	delete(localManifest.Merges, name)
+	if err := c.saveLocalCfgManifest(localManifestPath, localManifest); err != nil {
+		return err
+	}
+	fmt.Printf("configuration resolve completed successfully for: %s. Resolved: %d\n", name, len(state.Conflicts))
+	return c.Push(name, CfgStrategyNone)
+}
+
+func cfgHashesByPath(files []CfgManifestFile) map[string]string {
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		hashes[file.Path] = file.SHA256
+	}
+	return hashes
+}
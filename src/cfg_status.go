@@ -0,0 +1,169 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	cfgStatusInSync         = "in-sync"
+	cfgStatusLocalModified  = "local-modified"
+	cfgStatusRemoteAhead    = "remote-ahead"
+	cfgStatusDiverged       = "diverged"
+	cfgStatusConflicted     = "conflicted"
+	cfgStatusNotInitialized = "not-initialized"
+	cfgStatusError          = "error"
+)
+
+// CfgStatus describes how the local copy of a cfg entry relates to the
+// revision it was last synced at and to the current remote revision.
+type CfgStatus struct {
+	Name           string         `json:"name"`
+	State          string         `json:"state"`
+	LocalRevision  int64          `json:"local_revision"`
+	RemoteRevision int64          `json:"remote_revision"`
+	Local          CfgFileChanges `json:"local"`
+	Remote         CfgFileChanges `json:"remote"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// CfgFileChanges lists changed paths relative to the last synced revision.
+type CfgFileChanges struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Deleted  []string `json:"deleted"`
+}
+
+// Status reports the drift of the given entries, or of every entry in
+// settings when names is empty.
+func (c CfgCmd) Status(names []string, asJSON bool) error {
+	if len(names) == 0 {
+		for _, entry := range c.Context.Settings.Cfg {
+			names = append(names, entry.Name)
+		}
+	}
+	for _, name := range names {
+		if _, err := findEntry(c.Context.Settings.Cfg, name); err != nil {
+			return err
+		}
+	}
+	localManifest, err := c.loadLocalCfgManifest(c.buildLocalCfgManifestPath())
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]CfgStatus, 0, len(names))
+	failed := make([]string, 0)
+	for _, name := range names {
+		status, err := c.cfgStatus(name, localManifest)
+		if err != nil {
+			status = CfgStatus{Name: name, State: cfgStatusError, Error: err.Error()}
+			failed = append(failed, name)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if asJSON {
+		content, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	} else {
+		for _, status := range statuses {
+			c.printCfgStatus(status)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("configuration status failed for: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c CfgCmd) cfgStatus(name string, localManifest CfgManifest) (CfgStatus, error) {
+	entry, err := findEntry(c.Context.Settings.Cfg, name)
+	if err != nil {
+		return CfgStatus{}, err
+	}
+	files, err := c.buildCfgFileSnapshot(c.buildLocalCfgDir(name))
+	if err != nil {
+		return CfgStatus{}, err
+	}
+	remoteEntry, remoteExists, _, err := c.loadRemoteCfgEntry(entry, false)
+	if err != nil {
+		return CfgStatus{}, err
+	}
+
+	status := CfgStatus{Name: name}
+	localEntry, localExists := localManifest.Entries[name]
+	if localExists {
+		status.LocalRevision = localEntry.Revision
+	}
+	if remoteExists {
+		status.RemoteRevision = remoteEntry.Revision
+	}
+
+	localChanges := c.diffCfgFiles(localEntry.Files, files)
+	remoteChanges := cfgFileChanges{}
+	if status.RemoteRevision > status.LocalRevision {
+		remoteChanges = c.diffCfgFiles(localEntry.Files, remoteEntry.Files)
+	}
+	status.Local = newCfgFileChanges(localChanges)
+	status.Remote = newCfgFileChanges(remoteChanges)
+
+	localModified := !localChanges.isEmpty() || status.LocalRevision > status.RemoteRevision
+	remoteAhead := status.RemoteRevision > status.LocalRevision
+	switch {
+	case !localExists && len(files) == 0:
+		status.State = cfgStatusNotInitialized
+	case localManifest.Merges[name].Conflicts != nil:
+		status.State = cfgStatusConflicted
+	case localModified && remoteAhead:
+		status.State = cfgStatusDiverged
+	case localModified:
+		status.State = cfgStatusLocalModified
+	case remoteAhead:
+		status.State = cfgStatusRemoteAhead
+	default:
+		status.State = cfgStatusInSync
+	}
+	return status, nil
+}
+
+func (c CfgCmd) printCfgStatus(status CfgStatus) {
+	if status.State == cfgStatusError {
+		fmt.Printf("%s: %s. %s\n", status.Name, status.State, status.Error)
+		return
+	}
+	fmt.Printf("%s: %s. Local revision: %d. Remote revision: %d\n", status.Name, status.State, status.LocalRevision, status.RemoteRevision)
+	for _, side := range []struct {
+		name    string
+		changes CfgFileChanges
+	}{{"local", status.Local}, {"remote", status.Remote}} {
+		for _, path := range side.changes.Added {
+			fmt.Printf("  %s added:    %s\n", side.name, path)
+		}
+		for _, path := range side.changes.Modified {
+			fmt.Printf("  %s modified: %s\n", side.name, path)
+		}
+		for _, path := range side.changes.Deleted {
+			fmt.Printf("  %s deleted:  %s\n", side.name, path)
+		}
+	}
+}
+
+func newCfgFileChanges(changes cfgFileChanges) CfgFileChanges {
+	paths := func(files []CfgManifestFile) []string {
+		result := make([]string, 0, len(files))
+		for _, file := range files {
+			result = append(result, file.Path)
+		}
+		return result
+	}
+	return CfgFileChanges{
+		Added:    paths(changes.added),
+		Modified: paths(changes.changed),
+		Deleted:  paths(changes.removed),
+	}
+}
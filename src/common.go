@@ -1,26 +1,40 @@
 package src
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type ConfigEntry struct {
-	Name string     `json:"name"`
-	OSS  string     `json:"oss"`
-	Link LinkConfig `json:"link"`
-	Cmd  []string   `json:"cmd"`
+	Name   string     `json:"name"`
+	OSS    string     `json:"oss"`
+	Link   LinkConfig `json:"link"`
+	Cmd    []string   `json:"cmd"`
+	SHA256 string     `json:"sha256"`
+	Remote string     `json:"remote"`
+	// Encrypt stores the files of a cfg entry encrypted on the remote.
+	Encrypt bool `json:"encrypt"`
 }
 
 type Settings struct {
-	Version int           `json:"version"`
-	Cfg     []ConfigEntry `json:"cfg"`
-	Lib     []ConfigEntry `json:"lib"`
-	OSS     OSSConfig     `json:"oss"`
+	Version int                  `json:"version"`
+	Cfg     []ConfigEntry        `json:"cfg"`
+	Lib     []ConfigEntry        `json:"lib"`
+	OSS     OSSConfig            `json:"oss"`
+	Remotes map[string]OSSConfig `json:"remotes"`
+	Jobs    int                  `json:"jobs"`
+	// Encryption references the secret for cfg entries with encrypt set.
+	Encryption EncryptionConfig `json:"encryption"`
 }
 
 type LinkConfig []string
@@ -47,13 +61,24 @@ func (l *LinkConfig) UnmarshalJSON(data []byte) error {
 }
 
 const (
-	defaultCfgOSSPrefix = "donk/cfg"
-	defaultLibOSSPrefix = "donk/lib"
+	defaultCfgOSSPrefix  = "donk/cfg"
+	defaultLibOSSPrefix  = "donk/lib"
+	defaultBlobOSSPrefix = "donk/blobs"
 )
 
 type Context struct {
 	Dir      string
 	Settings Settings
+	// Backends overrides the registered backend for a URI scheme.
+	Backends map[string]Backend
+	// Ctx is canceled when the command is interrupted.
+	Ctx context.Context
+	// Jobs overrides Settings.Jobs for this command.
+	Jobs int
+	// Progress receives transfer progress. Nothing is reported when nil.
+	Progress ProgressReporter
+	// DryRun makes mutating commands print their plan instead of running it.
+	DryRun bool
 }
 
 func LoadContext(dir string) (Context, error) {
@@ -84,28 +109,130 @@ func LoadSettings(path string) (Settings, error) {
 }
 
 func (s *Settings) normalizeEntryOSS() error {
-	bucket := strings.Trim(s.OSS.Bucket, "/")
 	for idx := range s.Cfg {
-		if strings.TrimSpace(s.Cfg[idx].OSS) != "" {
-			continue
-		}
-		if bucket == "" {
-			return fmt.Errorf("cfg entry is missing oss and cannot use default because oss.bucket is empty. Entry name: %s", s.Cfg[idx].Name)
+		if err := s.normalizeEntry(&s.Cfg[idx], "cfg", defaultCfgOSSPrefix); err != nil {
+			return err
 		}
-		s.Cfg[idx].OSS = fmt.Sprintf("oss://%s/%s/%s", bucket, defaultCfgOSSPrefix, s.Cfg[idx].Name)
 	}
 	for idx := range s.Lib {
-		if strings.TrimSpace(s.Lib[idx].OSS) != "" {
-			continue
-		}
-		if bucket == "" {
-			return fmt.Errorf("lib entry is missing oss and cannot use default because oss.bucket is empty. Entry name: %s", s.Lib[idx].Name)
+		if err := s.normalizeEntry(&s.Lib[idx], "lib", defaultLibOSSPrefix); err != nil {
+			return err
 		}
-		s.Lib[idx].OSS = fmt.Sprintf("oss://%s/%s/%s", bucket, defaultLibOSSPrefix, s.Lib[idx].Name)
 	}
 	return nil
 }
 
+func (s *Settings) normalizeEntry(entry *ConfigEntry, kind string, prefix string) error {
+	remote, err := s.remoteConfig(entry.Remote)
+	if err != nil {
+		return fmt.Errorf("%s entry %w. Entry name: %s", kind, err, entry.Name)
+	}
+	if strings.TrimSpace(entry.OSS) != "" {
+		return nil
+	}
+	root, err := remoteRoot(remote)
+	if err != nil {
+		return fmt.Errorf("%s entry is missing oss and cannot use default because %w. Entry name: %s", kind, err, entry.Name)
+	}
+	entry.OSS = joinRemotePath(root, prefix+"/"+entry.Name)
+	return nil
+}
+
+// remoteConfig returns the named remote, or the top-level oss config when the
+// name is empty.
+func (s *Settings) remoteConfig(name string) (OSSConfig, error) {
+	if name == "" {
+		return s.OSS, nil
+	}
+	remote, ok := s.Remotes[name]
+	if !ok {
+		return OSSConfig{}, fmt.Errorf("refers to a remote that is not defined in remotes: %s", name)
+	}
+	return remote, nil
+}
+
+// resolveRemote picks the storage config for a remote path. A remote matches
+// when its provider uses the path scheme and its bucket prefixes the path. When
+// nothing matches, the first remote with the same scheme is used so that the
+// backend can report the mismatch.
+func (s *Settings) resolveRemote(uri string) OSSConfig {
+	if remote, ok := s.matchRemote(uri); ok {
+		return remote
+	}
+	scheme := uriScheme(uri)
+	for _, candidate := range s.remoteCandidates() {
+		if root, err := remoteRoot(candidate); err == nil && uriScheme(root) == scheme {
+			return candidate
+		}
+	}
+	return OSSConfig{}
+}
+
+// matchRemote returns the remote whose bucket contains uri, checking the
+// top-level oss config first and then remotes by name.
+func (s *Settings) matchRemote(uri string) (OSSConfig, bool) {
+	for _, candidate := range s.remoteCandidates() {
+		if remoteContains(candidate, uri) {
+			return candidate, true
+		}
+	}
+	return OSSConfig{}, false
+}
+
+func (s *Settings) remoteCandidates() []OSSConfig {
+	candidates := []OSSConfig{s.OSS}
+	names := make([]string, 0, len(s.Remotes))
+	for name := range s.Remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		candidates = append(candidates, s.Remotes[name])
+	}
+	return candidates
+}
+
+// remoteContains reports whether uri lies inside the bucket of remote.
+func remoteContains(remote OSSConfig, uri string) bool {
+	root, err := remoteRoot(remote)
+	if err != nil || uriScheme(root) != uriScheme(uri) {
+		return false
+	}
+	root = strings.TrimRight(trimRootSlashes(root), "/")
+	uri = strings.TrimRight(trimRootSlashes(uri), "/")
+	return uri == root || strings.HasPrefix(uri, root+"/")
+}
+
+// trimRootSlashes drops the slashes after the scheme, so file:///srv and
+// file://srv compare equal.
+func trimRootSlashes(uri string) string {
+	scheme := uriScheme(uri)
+	return scheme + "://" + strings.TrimLeft(uri[len(scheme)+len("://"):], "/")
+}
+
+// entryRemoteRoot returns the root of the remote that holds the manifests,
+// blobs and lock of a cfg entry. That is the remote whose bucket contains the
+// entry's oss path, so an explicit oss path keeps all of the entry's data on
+// the remote it names. A path outside the remote named by remote, or outside
+// every remote, is rejected rather than stored somewhere else.
+func (s *Settings) entryRemoteRoot(entry ConfigEntry) (string, error) {
+	remote, ok := s.matchRemote(entry.OSS)
+	if entry.Remote != "" {
+		named, err := s.remoteConfig(entry.Remote)
+		if err != nil {
+			return "", fmt.Errorf("cfg entry %w. Entry name: %s", err, entry.Name)
+		}
+		remote, ok = named, remoteContains(named, entry.OSS)
+		if !ok {
+			return "", fmt.Errorf("cfg entry cannot be synced because its oss path is outside of its remote %s. Entry name: %s. Path: %s", entry.Remote, entry.Name, entry.OSS)
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("cfg entry cannot be synced because its oss path is outside of every configured remote. Add a remote whose bucket contains the path. Entry name: %s. Path: %s", entry.Name, entry.OSS)
+	}
+	return remoteRoot(remote)
+}
+
 func findEntry(entries []ConfigEntry, name string) (ConfigEntry, error) {
 	for _, entry := range entries {
 		if entry.Name == name {
@@ -272,28 +399,79 @@ func errorsIsNotExist(err error) bool {
 	return err != nil && os.IsNotExist(err)
 }
 
-func pullSource(settings Settings, src string, dst string) error {
-	if strings.HasPrefix(src, "oss://") {
-		ossClient, err := NewOSSClient(settings.OSS)
-		if err != nil {
-			return err
-		}
-		return ossClient.Pull(src, dst)
-	} else {
-		return fmt.Errorf("unsupported data source. Only oss paths are currently supported: %s", src)
+func (c Context) openBackend(uri string) (Backend, error) {
+	if backend, ok := c.Backends[uriScheme(uri)]; ok {
+		return backend, nil
 	}
+	return OpenBackend(c.Settings, uri, c.backendOptions())
 }
 
-func pushSource(settings Settings, src string, dst string) error {
-	if strings.HasPrefix(dst, "oss://") {
-		ossClient, err := NewOSSClient(settings.OSS)
-		if err != nil {
-			return err
-		}
-		return ossClient.Push(src, dst)
-	} else {
-		return fmt.Errorf("unsupported data destination. Only oss paths are currently supported: %s", dst)
+// applyJobsFlag lets --jobs override the jobs setting for one command.
+func (c *Context) applyJobsFlag(parsed cmdArgs) error {
+	if !parsed.has("jobs") {
+		return nil
+	}
+	jobs, err := strconv.Atoi(parsed.value("jobs"))
+	if err != nil || jobs <= 0 {
+		return fmt.Errorf("invalid command arguments because --jobs must be a positive number: %s", parsed.value("jobs"))
+	}
+	c.Jobs = jobs
+	return nil
+}
+
+// ctx returns the context commands run under, never nil.
+func (c Context) ctx() context.Context {
+	return c.backendOptions().ctx()
+}
+
+func (c Context) backendOptions() BackendOptions {
+	jobs := c.Jobs
+	if jobs <= 0 {
+		jobs = c.Settings.Jobs
+	}
+	return BackendOptions{Ctx: c.Ctx, Jobs: jobs, Progress: c.Progress, DryRun: c.DryRun}
+}
+
+func pullSource(context Context, src string, dst string) error {
+	backend, err := context.openBackend(src)
+	if err != nil {
+		return err
+	}
+	return backend.Pull(src, dst)
+}
+
+func pushSource(context Context, src string, dst string) error {
+	backend, err := context.openBackend(dst)
+	if err != nil {
+		return err
+	}
+	return backend.Push(src, dst)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func updatedByIdentity() (string, string) {
+	user := os.Getenv("USER")
+	host, _ := os.Hostname()
+	if user == "" {
+		user = "unknown"
+	}
+	if host == "" {
+		host = "unknown"
 	}
+	return user, user + "@" + host
 }
 
 func runCommands(commands []string) error {
@@ -310,3 +488,55 @@ func runCommands(commands []string) error {
 	}
 	return nil
 }
+
+// cmdArgs holds positional arguments and --flags parsed from a command line.
+type cmdArgs struct {
+	positional []string
+	flags      map[string]string
+}
+
+// parseCmdArgs splits args into positional arguments and flags. Bool flags
+// take no value, value flags accept both "--name value" and "--name=value".
+func parseCmdArgs(args []string, boolFlags []string, valueFlags []string) (cmdArgs, error) {
+	parsed := cmdArgs{flags: map[string]string{}}
+	isBool := map[string]bool{}
+	for _, name := range boolFlags {
+		isBool[name] = true
+	}
+	isValue := map[string]bool{}
+	for _, name := range valueFlags {
+		isValue[name] = true
+	}
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			parsed.positional = append(parsed.positional, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		switch {
+		case isBool[name] && !hasValue:
+			parsed.flags[name] = "true"
+		case isValue[name] && hasValue:
+			parsed.flags[name] = value
+		case isValue[name] && idx+1 < len(args):
+			idx++
+			parsed.flags[name] = args[idx]
+		case isValue[name]:
+			return cmdArgs{}, fmt.Errorf("flag --%s requires a value", name)
+		default:
+			return cmdArgs{}, fmt.Errorf("unknown flag: %s", arg)
+		}
+	}
+	return parsed, nil
+}
+
+func (a cmdArgs) has(name string) bool {
+	_, ok := a.flags[name]
+	return ok
+}
+
+func (a cmdArgs) value(name string) string {
+	return a.flags[name]
+}
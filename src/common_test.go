@@ -0,0 +1,151 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntryRemoteRoot(t *testing.T) {
+	settings := Settings{
+		OSS: OSSConfig{Name: "aliyun-oss", Bucket: "team"},
+		Remotes: map[string]OSSConfig{
+			"personal": {Name: "aliyun-oss", Bucket: "personal-bucket"},
+			"local":    {Name: "file", Bucket: "/srv/donk"},
+		},
+	}
+	tests := []struct {
+		name    string
+		entry   ConfigEntry
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "default path",
+			entry: ConfigEntry{Name: "nvim", OSS: "oss://team/donk/cfg/nvim"},
+			want:  "oss://team",
+		},
+		{
+			name:  "explicit path picks the remote of its bucket",
+			entry: ConfigEntry{Name: "nvim", OSS: "oss://personal-bucket/dotfiles/nvim"},
+			want:  "oss://personal-bucket",
+		},
+		{
+			name:  "explicit path of another provider",
+			entry: ConfigEntry{Name: "nvim", OSS: "file:///srv/donk/nvim"},
+			want:  "file://srv/donk",
+		},
+		{
+			name:  "named remote",
+			entry: ConfigEntry{Name: "nvim", Remote: "personal", OSS: "oss://personal-bucket/donk/cfg/nvim"},
+			want:  "oss://personal-bucket",
+		},
+		{
+			name:    "path outside the named remote",
+			entry:   ConfigEntry{Name: "nvim", Remote: "personal", OSS: "oss://team/nvim"},
+			wantErr: "outside of its remote personal",
+		},
+		{
+			name:    "path outside every remote",
+			entry:   ConfigEntry{Name: "nvim", OSS: "file:///elsewhere/nvim"},
+			wantErr: "outside of every configured remote",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := settings.entryRemoteRoot(tt.entry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("entryRemoteRoot() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("entryRemoteRoot() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureSymlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(src string, link string)
+		wantErr string
+	}{
+		{
+			name:  "missing link",
+			setup: func(src string, link string) {},
+		},
+		{
+			name: "link to the target",
+			setup: func(src string, link string) {
+				if err := os.Symlink(src, link); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "relative link to the target",
+			setup: func(src string, link string) {
+				rel, err := filepath.Rel(filepath.Dir(link), src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(rel, link); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "link to another target",
+			setup: func(src string, link string) {
+				if err := os.Symlink(t.TempDir(), link); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "it points to a different target",
+		},
+		{
+			name: "file",
+			setup: func(src string, link string) {
+				if err := os.WriteFile(link, []byte("occupied"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "the link path is occupied by a file or directory",
+		},
+		{
+			name: "directory",
+			setup: func(src string, link string) {
+				if err := os.Mkdir(link, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "the link path is occupied by a file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			src := filepath.Join(root, "src")
+			if err := os.Mkdir(src, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			link := filepath.Join(root, "config", "nvim")
+			if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(src, link)
+			checkTestErr(t, ensureSymlink(src, link), tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			target, err := filepath.EvalSymlinks(link)
+			if err != nil || target != src {
+				t.Fatalf("link resolves to %s, %v, want %s", target, err, src)
+			}
+		})
+	}
+}
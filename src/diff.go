@@ -0,0 +1,392 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const diffContextLines = 3
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+// splitLines splits content after every newline. The last line has no
+// newline when the content does not end with one.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isBinaryContent uses the same heuristic as git: a NUL byte near the start.
+func isBinaryContent(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// diffLines returns the shortest edit script that turns a into b, computed
+// with the linear-space variant of Myers' algorithm, so that two large files
+// with nothing in common do not need memory quadratic in their size. Within
+// every changed region the deletions come before the insertions.
+func diffLines(a []string, b []string) []diffOp {
+	size := len(a) + len(b) + 1
+	d := myersDiff{
+		a:      a,
+		b:      b,
+		fwd:    make([]int, 2*size+1),
+		bwd:    make([]int, 2*size+1),
+		offset: size,
+		ops:    make([]diffOp, 0, len(a)+len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return groupDiffChanges(d.ops)
+}
+
+type myersDiff struct {
+	a, b     []string
+	fwd, bwd []int
+	offset   int
+	ops      []diffOp
+}
+
+// compare appends the edit script for a[aLo:aHi] and b[bLo:bHi]. It splits
+// both ranges at the middle snake of an optimal path and recurses into the
+// halves, each of which needs fewer edits than the whole.
+func (d *myersDiff) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{diffEqual, d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := aHi
+	for aHi > aLo && bHi > bLo && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.ops = append(d.ops, diffOp{diffInsert, line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.ops = append(d.ops, diffOp{diffDelete, line})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for _, line := range d.a[x:u] {
+			d.ops = append(d.ops, diffOp{diffEqual, line})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for _, line := range d.a[aHi:suffix] {
+		d.ops = append(d.ops, diffOp{diffEqual, line})
+	}
+}
+
+// middleSnake runs Myers' search from both ends of the ranges at once and
+// returns the snake, from (x, y) to (u, v), where the two searches meet.
+func (d *myersDiff) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	fwd, bwd, off := d.fwd, d.bwd, d.offset
+	fwd[off+1], bwd[off+1] = 0, 0
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			x := fwd[off+k-1] + 1
+			if k == -step || (k != step && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			if back := delta - k; odd && back >= -(step-1) && back <= step-1 && x+bwd[off+back] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+		// The backward search walks both ranges from their ends, so x and y
+		// count lines from aHi and bHi.
+		for k := -step; k <= step; k += 2 {
+			x := bwd[off+k-1] + 1
+			if k == -step || (k != step && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			bwd[off+k] = x
+			if forward := delta - k; !odd && forward >= -step && forward <= step && x+fwd[off+forward] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+	// Unreachable: the searches always meet after (n+m+1)/2 steps.
+	return aLo, bLo, aLo, bLo
+}
+
+// groupDiffChanges moves the deletions of every changed region in front of its
+// insertions.
+func groupDiffChanges(ops []diffOp) []diffOp {
+	grouped := make([]diffOp, 0, len(ops))
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			grouped = append(grouped, ops[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(ops) && ops[end].kind != diffEqual {
+			end++
+		}
+		for _, kind := range []diffOpKind{diffDelete, diffInsert} {
+			for _, op := range ops[i:end] {
+				if op.kind == kind {
+					grouped = append(grouped, op)
+				}
+			}
+		}
+		i = end
+	}
+	return grouped
+}
+
+// countDiffLines returns how many lines an edit script inserts and deletes.
+func countDiffLines(ops []diffOp) (int, int) {
+	insertions, deletions := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case diffInsert:
+			insertions++
+		case diffDelete:
+			deletions++
+		}
+	}
+	return insertions, deletions
+}
+
+// unifiedDiff formats an edit script in the unified format of diff -u.
+func unifiedDiff(oldName string, newName string, ops []diffOp) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.kind != diffInsert {
+			oldPos[i+1]++
+		}
+		if op.kind != diffDelete {
+			newPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		// Grow the hunk while the next change is close enough for the
+		// context of both to overlap.
+		start := max(0, i-diffContextLines)
+		end := i + 1
+		for j := i + 1; j < len(ops) && j <= end+2*diffContextLines; j++ {
+			if ops[j].kind != diffEqual {
+				end = j + 1
+			}
+		}
+		end = min(len(ops), end+diffContextLines)
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			unifiedRange(oldPos[start], oldPos[end]-oldPos[start]),
+			unifiedRange(newPos[start], newPos[end]-newPos[start]))
+		for _, op := range ops[start:end] {
+			prefix := " "
+			switch op.kind {
+			case diffDelete:
+				prefix = "-"
+			case diffInsert:
+				prefix = "+"
+			}
+			out.WriteString(prefix + op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func unifiedRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// mergeResolution says how mergeLines handles regions changed differently on
+// both sides.
+type mergeResolution int
+
+const (
+	mergeMarkConflicts mergeResolution = iota
+	mergeTakeLocal
+	mergeTakeRemote
+)
+
+const (
+	mergeMarkerLocal  = "<<<<<<< local\n"
+	mergeMarkerSplit  = "=======\n"
+	mergeMarkerRemote = ">>>>>>> remote\n"
+)
+
+// matchedLines maps every line of base to the index of the equal line in
+// other, or -1 when the line was deleted.
+func matchedLines(ops []diffOp, baseSize int) []int {
+	matches := make([]int, baseSize)
+	baseIdx, otherIdx := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case diffEqual:
+			matches[baseIdx] = otherIdx
+			baseIdx++
+			otherIdx++
+		case diffDelete:
+			matches[baseIdx] = -1
+			baseIdx++
+		case diffInsert:
+			otherIdx++
+		}
+	}
+	return matches
+}
+
+// mergeLines merges the changes that local and remote made to base, in the
+// manner of diff3. Regions changed differently on both sides are written
+// between conflict markers unless resolution picks a side, and the returned
+// count says how many there are.
+func mergeLines(base []string, local []string, remote []string, resolution mergeResolution) ([]string, int) {
+	matchLocal := matchedLines(diffLines(base, local), len(base))
+	matchRemote := matchedLines(diffLines(base, remote), len(base))
+
+	merged := make([]string, 0, len(local))
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for i < len(base) || j < len(local) || k < len(remote) {
+		// Copy lines that are unchanged on both sides.
+		stable := 0
+		for i+stable < len(base) && matchLocal[i+stable] == j+stable && matchRemote[i+stable] == k+stable {
+			stable++
+		}
+		if stable > 0 {
+			merged = append(merged, base[i:i+stable]...)
+			i, j, k = i+stable, j+stable, k+stable
+			continue
+		}
+
+		// The changed region ends at the next base line both sides kept.
+		nextI, nextJ, nextK := len(base), len(local), len(remote)
+		for idx := i; idx < len(base); idx++ {
+			if matchLocal[idx] >= 0 && matchRemote[idx] >= 0 {
+				nextI, nextJ, nextK = idx, matchLocal[idx], matchRemote[idx]
+				break
+			}
+		}
+		baseChunk, localChunk, remoteChunk := base[i:nextI], local[j:nextJ], remote[k:nextK]
+		switch {
+		case slices.Equal(localChunk, baseChunk) || slices.Equal(localChunk, remoteChunk):
+			merged = append(merged, remoteChunk...)
+		case slices.Equal(remoteChunk, baseChunk):
+			merged = append(merged, localChunk...)
+		default:
+			conflicts++
+			switch resolution {
+			case mergeTakeLocal:
+				merged = append(merged, localChunk...)
+			case mergeTakeRemote:
+				merged = append(merged, remoteChunk...)
+			default:
+				merged = append(merged, mergeMarkerLocal)
+				merged = appendTerminated(merged, localChunk)
+				merged = append(merged, mergeMarkerSplit)
+				merged = appendTerminated(merged, remoteChunk)
+				merged = append(merged, mergeMarkerRemote)
+			}
+		}
+		i, j, k = nextI, nextJ, nextK
+	}
+	return merged, conflicts
+}
+
+// appendTerminated appends lines and makes sure the last one ends with a
+// newline, so a following conflict marker starts on its own line.
+func appendTerminated(dst []string, lines []string) []string {
+	dst = append(dst, lines...)
+	if len(dst) > 0 && !strings.HasSuffix(dst[len(dst)-1], "\n") {
+		dst[len(dst)-1] += "\n"
+	}
+	return dst
+}
+
+// hasConflictMarkers reports whether content still holds markers written by
+// mergeLines.
+func hasConflictMarkers(content []byte) bool {
+	for _, line := range splitLines(content) {
+		if line == mergeMarkerLocal || line == mergeMarkerRemote {
+			return true
+		}
+	}
+	return false
+}
+
+// keepMergeSide replaces every conflict of a merged file with the lines of one
+// side.
+func keepMergeSide(content []byte, local bool) []byte {
+	var out strings.Builder
+	inLocal, inRemote := false, false
+	for _, line := range splitLines(content) {
+		switch {
+		case line == mergeMarkerLocal:
+			inLocal = true
+		case line == mergeMarkerSplit && inLocal:
+			inLocal, inRemote = false, true
+		case line == mergeMarkerRemote && inRemote:
+			inRemote = false
+		case inLocal && !local, inRemote && local:
+		default:
+			out.WriteString(line)
+		}
+	}
+	return []byte(out.String())
+}
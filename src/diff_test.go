@@ -0,0 +1,177 @@
+package src
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{name: "equal", a: "a\nb\n", b: "a\nb\n", want: " a\n b\n"},
+		{name: "empty old", a: "", b: "a\nb\n", want: "+a\n+b\n"},
+		{name: "empty new", a: "a\nb\n", b: "", want: "-a\n-b\n"},
+		{name: "insert", a: "a\nc\n", b: "a\nb\nc\n", want: " a\n+b\n c\n"},
+		{name: "delete", a: "a\nb\nc\n", b: "a\nc\n", want: " a\n-b\n c\n"},
+		{name: "replace groups deletions first", a: "a\nb\nc\nd\n", b: "a\nx\ny\nd\n", want: " a\n-b\n-c\n+x\n+y\n d\n"},
+		{name: "nothing in common", a: "a\nb\n", b: "c\nd\n", want: "-a\n-b\n+c\n+d\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDiffOps(diffLines(splitLines([]byte(tt.a)), splitLines([]byte(tt.b)))); got != tt.want {
+				t.Fatalf("diffLines() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := randomLines(rng, rng.Intn(30))
+		b := randomLines(rng, rng.Intn(30))
+		ops := diffLines(a, b)
+		oldLines, newLines := applyDiffOps(ops)
+		if !slices.Equal(oldLines, a) || !slices.Equal(newLines, b) {
+			t.Fatalf("diffLines(%q, %q) does not reproduce its inputs", a, b)
+		}
+		insertions, deletions := countDiffLines(ops)
+		if want := len(a) + len(b) - 2*lcsLength(a, b); insertions+deletions != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, insertions+deletions, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeDisjointFiles(t *testing.T) {
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i] = fmt.Sprintf("old %d\n", i)
+		b[i] = fmt.Sprintf("new %d\n", i)
+	}
+	insertions, deletions := countDiffLines(diffLines(a, b))
+	if insertions != len(b) || deletions != len(a) {
+		t.Fatalf("countDiffLines() = %d, %d, want %d, %d", insertions, deletions, len(b), len(a))
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		name       string
+		base       string
+		local      string
+		remote     string
+		resolution mergeResolution
+		want       string
+		conflicts  int
+	}{
+		{
+			name:   "separate changes",
+			base:   "a\nb\nc\nd\ne\n",
+			local:  "A\nb\nc\nd\ne\n",
+			remote: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "same change",
+			base:   "a\nb\n",
+			local:  "a\nB\n",
+			remote: "a\nB\n",
+			want:   "a\nB\n",
+		},
+		{
+			name:      "conflict",
+			base:      "a\nb\nc\n",
+			local:     "a\nL\nc\n",
+			remote:    "a\nR\nc\n",
+			want:      "a\n<<<<<<< local\nL\n=======\nR\n>>>>>>> remote\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:       "conflict takes local",
+			base:       "a\nb\nc\n",
+			local:      "a\nL\nc\n",
+			remote:     "a\nR\nc\n",
+			resolution: mergeTakeLocal,
+			want:       "a\nL\nc\n",
+			conflicts:  1,
+		},
+		{
+			name:       "conflict takes remote",
+			base:       "a\nb\nc\n",
+			local:      "a\nL\nc\n",
+			remote:     "a\nR\nc\n",
+			resolution: mergeTakeRemote,
+			want:       "a\nR\nc\n",
+			conflicts:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := mergeLines(splitLines([]byte(tt.base)), splitLines([]byte(tt.local)), splitLines([]byte(tt.remote)), tt.resolution)
+			if got := strings.Join(merged, ""); got != tt.want || conflicts != tt.conflicts {
+				t.Fatalf("mergeLines() = %q, %d, want %q, %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
+
+func formatDiffOps(ops []diffOp) string {
+	var out strings.Builder
+	for _, op := range ops {
+		switch op.kind {
+		case diffEqual:
+			out.WriteString(" ")
+		case diffDelete:
+			out.WriteString("-")
+		case diffInsert:
+			out.WriteString("+")
+		}
+		out.WriteString(op.line)
+	}
+	return out.String()
+}
+
+func applyDiffOps(ops []diffOp) ([]string, []string) {
+	var oldLines, newLines []string
+	for _, op := range ops {
+		if op.kind != diffInsert {
+			oldLines = append(oldLines, op.line)
+		}
+		if op.kind != diffDelete {
+			newLines = append(newLines, op.line)
+		}
+	}
+	return oldLines, newLines
+}
+
+func randomLines(rng *rand.Rand, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = string(rune('a'+rng.Intn(4))) + "\n"
+	}
+	return lines
+}
+
+func lcsLength(a []string, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,58 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dryRunPlan collects what a command run with --dry-run would do. Commands
+// add a step wherever they would write to disk or to a remote, or run a
+// configured command, and print the plan instead.
+type dryRunPlan struct {
+	steps []string
+}
+
+func (p *dryRunPlan) add(action string, format string, args ...any) {
+	p.steps = append(p.steps, fmt.Sprintf("  %-9s %s", action, fmt.Sprintf(format, args...)))
+}
+
+func (p *dryRunPlan) print(header string) {
+	fmt.Println(header)
+	if len(p.steps) == 0 {
+		fmt.Println("  nothing to do")
+	}
+	for _, step := range p.steps {
+		fmt.Println(step)
+	}
+}
+
+// addSymlinks adds the links ensureSymlinks would create. Links that already
+// point at their target are left out.
+func (p *dryRunPlan) addSymlinks(plans []SymlinkPlan) {
+	for _, plan := range plans {
+		srcAbs, err := filepath.Abs(plan.src)
+		if err != nil {
+			srcAbs = plan.src
+		}
+		if current, err := os.Readlink(plan.link); err == nil {
+			if !filepath.IsAbs(current) {
+				current = filepath.Join(filepath.Dir(plan.link), current)
+			}
+			if filepath.Clean(current) == srcAbs {
+				continue
+			}
+		}
+		p.add("symlink", "%s -> %s", plan.link, srcAbs)
+	}
+}
+
+// addCommands adds the commands runCommands would execute.
+func (p *dryRunPlan) addCommands(commands []string) {
+	for _, command := range commands {
+		if strings.TrimSpace(command) != "" {
+			p.add("run", "%s", command)
+		}
+	}
+}
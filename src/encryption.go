@@ -0,0 +1,188 @@
+package src
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// Encrypted blobs start with encryptionMagic, followed by a byte naming
+	// the key derivation, the salt and the AES-GCM nonce.
+	encryptionMagic            = "DONKENC1"
+	encryptionKDFKeyFile       = byte(1)
+	encryptionKDFPassphrase    = byte(2)
+	encryptionSaltSize         = 16
+	encryptionPBKDF2Iterations = 600000
+	encryptedBlobDirName       = "encrypted"
+	// Encrypted blobs are named by an HMAC of the plaintext sha256, so the
+	// storage provider cannot tell which content a blob holds.
+	encryptedBlobNameAlgorithm = "hmac-sha256"
+	encryptionBlobNameSalt     = "donk blob name"
+)
+
+var errEncryptionKeyMismatch = errors.New("the blob cannot be decrypted with the configured key")
+
+// EncryptionConfig references the secret used for cfg entries with encrypt
+// set. Settings never hold the secret itself, only the key file path or the
+// name of the environment variable holding a passphrase.
+type EncryptionConfig struct {
+	KeyFile       string `json:"key_file"`
+	PassphraseEnv string `json:"passphrase_env"`
+}
+
+// blobCipher encrypts blobs with AES-256-GCM. Every command encrypts with one
+// random salt, and keys derived for the salts of existing blobs are cached,
+// so the slow passphrase derivation runs once per salt.
+type blobCipher struct {
+	kdf    byte
+	secret []byte
+	salt   []byte
+
+	mu      sync.Mutex
+	keys    map[string]cipher.AEAD
+	nameKey []byte
+}
+
+func newBlobCipher(cfg EncryptionConfig) (*blobCipher, error) {
+	c := &blobCipher{keys: map[string]cipher.AEAD{}}
+	switch {
+	case cfg.KeyFile != "":
+		path, err := expandPath(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("encryption failed because the key file cannot be read: %w", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) < 16 {
+			return nil, fmt.Errorf("encryption failed because the key file holds fewer than 16 bytes: %s", path)
+		}
+		c.kdf, c.secret = encryptionKDFKeyFile, secret
+	case cfg.PassphraseEnv != "":
+		passphrase := os.Getenv(cfg.PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("encryption failed because the passphrase environment variable is empty: %s", cfg.PassphraseEnv)
+		}
+		c.kdf, c.secret = encryptionKDFPassphrase, []byte(passphrase)
+	default:
+		return nil, errors.New("encryption failed because settings do not define encryption.key_file or encryption.passphrase_env")
+	}
+
+	c.salt = make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(c.salt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *blobCipher) encrypt(plain []byte) ([]byte, error) {
+	aead, err := c.aead(c.kdf, c.salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append(append(append([]byte(encryptionMagic), c.kdf), c.salt...), nonce...)
+	return aead.Seal(header, nonce, plain, header), nil
+}
+
+func (c *blobCipher) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptionMagic)) || len(data) < len(encryptionMagic)+1+encryptionSaltSize {
+		return nil, errors.New("decryption failed because the blob is not encrypted by donk")
+	}
+	kdf := data[len(encryptionMagic)]
+	salt := data[len(encryptionMagic)+1 : len(encryptionMagic)+1+encryptionSaltSize]
+	aead, err := c.aead(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(encryptionMagic) + 1 + encryptionSaltSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return nil, errors.New("decryption failed because the blob is truncated")
+	}
+	header := data[:headerSize]
+	plain, err := aead.Open(nil, header[headerSize-aead.NonceSize():], data[headerSize:], header)
+	if err != nil {
+		return nil, errEncryptionKeyMismatch
+	}
+	return plain, nil
+}
+
+// blobName returns the remote name of the blob with the given plaintext hash.
+// The HMAC key is derived from the secret with a fixed salt, so every machine
+// sharing the secret arrives at the same name.
+func (c *blobCipher) blobName(hash string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nameKey == nil {
+		var err error
+		if c.kdf == encryptionKDFPassphrase {
+			c.nameKey, err = pbkdf2.Key(sha256.New, string(c.secret), []byte(encryptionBlobNameSalt), encryptionPBKDF2Iterations, 32)
+		} else {
+			c.nameKey, err = hkdf.Key(sha256.New, c.secret, []byte(encryptionBlobNameSalt), "donk blob name", 32)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	mac := hmac.New(sha256.New, c.nameKey)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (c *blobCipher) aead(kdf byte, salt []byte) (cipher.AEAD, error) {
+	if kdf != c.kdf {
+		return nil, fmt.Errorf("%w. The blob was encrypted with a %s, but settings reference a %s", errEncryptionKeyMismatch, kdfName(kdf), kdfName(c.kdf))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if aead, ok := c.keys[string(salt)]; ok {
+		return aead, nil
+	}
+
+	var key []byte
+	var err error
+	if kdf == encryptionKDFPassphrase {
+		key, err = pbkdf2.Key(sha256.New, string(c.secret), salt, encryptionPBKDF2Iterations, 32)
+	} else {
+		key, err = hkdf.Key(sha256.New, c.secret, salt, "donk blob", 32)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[string(salt)] = aead
+	return aead, nil
+}
+
+func kdfName(kdf byte) string {
+	switch kdf {
+	case encryptionKDFKeyFile:
+		return "key file"
+	case encryptionKDFPassphrase:
+		return "passphrase"
+	}
+	return "unknown key type"
+}
@@ -0,0 +1,123 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobCipher(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	first, err := newBlobCipher(EncryptionConfig{KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newBlobCipher(EncryptionConfig{KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := first.encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("secret")) {
+		t.Fatal("encrypt() leaves the plaintext in the blob")
+	}
+	plain, err := second.decrypt(encrypted)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("decrypt() = %q, %v, want secret", plain, err)
+	}
+
+	hash := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	firstName, err := first.blobName(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondName, err := second.blobName(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstName != secondName || firstName == hash {
+		t.Fatalf("blobName() = %s and %s, want one keyed name for both ciphers", firstName, secondName)
+	}
+
+	t.Setenv("DONK_TEST_PASSPHRASE", "another secret")
+	other, err := newBlobCipher(EncryptionConfig{PassphraseEnv: "DONK_TEST_PASSPHRASE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.decrypt(encrypted); !errors.Is(err, errEncryptionKeyMismatch) {
+		t.Fatalf("decrypt() with another key = %v, want %v", err, errEncryptionKeyMismatch)
+	}
+	if otherName, err := other.blobName(hash); err != nil || otherName == firstName {
+		t.Fatalf("blobName() with another key = %s, %v, want a different name", otherName, err)
+	}
+}
+
+func TestCfgEncryptedPushPull(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := func(m *testMachine, encryption EncryptionConfig) *testMachine {
+		m.context.Settings.Encryption = encryption
+		m.context.Settings.Cfg[0].Encrypt = true
+		return m
+	}
+	remote := newMemBackend(BackendOptions{})
+	author := encrypted(newTestMachine(t, remote), EncryptionConfig{KeyFile: keyFile})
+	files := map[string]string{"init.lua": "vim.g.token = 'secret'\n", "lua/keys.lua": "keys\n"}
+	author.write(files)
+	author.mustPush()
+
+	localManifest, err := author.cfg().loadLocalCfgManifest(author.cfg().buildLocalCfgManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]bool{}
+	for _, file := range localManifest.Entries["nvim"].Files {
+		hashes[file.SHA256] = true
+	}
+	if objects, err := remote.List("oss://team/donk/blobs/sha256"); err != nil || len(objects) != 0 {
+		t.Fatalf("plaintext blobs = %+v, %v, want none", objects, err)
+	}
+	blobs, err := remote.List("oss://team/donk/blobs/encrypted/hmac-sha256")
+	if err != nil || len(blobs) != len(files) {
+		t.Fatalf("encrypted blobs = %+v, %v, want %d", blobs, err, len(files))
+	}
+	for _, blob := range blobs {
+		content, err := remote.ReadObject("oss://team/donk/blobs/encrypted/hmac-sha256/" + blob.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashes[blob.Path] || bytes.Contains(content, []byte("secret")) || bytes.Contains(content, []byte("keys")) {
+			t.Fatalf("blob %s leaks its hash or its plaintext", blob.Path)
+		}
+	}
+
+	reader := encrypted(newTestMachine(t, remote), EncryptionConfig{KeyFile: keyFile})
+	reader.mustPull()
+	if got := reader.files(); !maps.Equal(got, files) {
+		t.Fatalf("pulled files = %v, want %v", got, files)
+	}
+	if info, err := os.Stat(filepath.Join(reader.context.Dir, "blobs", "sha256")); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("local blob cache = %v, %v, want mode 0700", info, err)
+	}
+
+	t.Setenv("DONK_TEST_PASSPHRASE", "another secret")
+	other := encrypted(newTestMachine(t, remote), EncryptionConfig{PassphraseEnv: "DONK_TEST_PASSPHRASE"})
+	// Another key names the blobs differently, so they are not even found.
+	if err := other.pull(CfgStrategyNone); !errors.Is(err, errRemoteNotFound) {
+		t.Fatalf("pull with another key = %v, want %v", err, errRemoteNotFound)
+	}
+	if got := other.files(); len(got) != 0 {
+		t.Fatalf("pull with another key wrote %v, want nothing", got)
+	}
+}
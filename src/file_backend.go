@@ -0,0 +1,304 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fileBackendProvider = "file"
+
+// fileTempPrefix names the files a write goes to before it is renamed into
+// place. It is reserved, so one left behind by a crash is never synced.
+const fileTempPrefix = reservedNamePrefix + "tmp-"
+
+type FileBackend struct {
+	opts BackendOptions
+}
+
+func init() {
+	RegisterBackend("file", fileBackendProvider, func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewFileBackend(cfg, opts)
+	})
+}
+
+func NewFileBackend(cfg OSSConfig, opts BackendOptions) (*FileBackend, error) {
+	return &FileBackend{opts: opts}, nil
+}
+
+func (f *FileBackend) Pull(src string, dst string) error {
+	return pullTree(f, f.opts, src, dst)
+}
+
+func (f *FileBackend) Push(src string, dst string) error {
+	return pushTree(f, f.opts, src, dst)
+}
+
+func (f *FileBackend) ReadObject(src string) ([]byte, error) {
+	path, err := f.parseUri(src)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Stat(src); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading file %s: %w", path, err)
+	}
+	return content, nil
+}
+
+func (f *FileBackend) WriteObject(dst string, content []byte) error {
+	path, err := f.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// CreateObject writes dst only when it does not exist yet. The content is
+// written to a temporary file first and linked into place, which fails when
+// dst exists, so readers never see a partial object. File systems without
+// hard links get an exclusive create instead. Replacing an existing object
+// conditionally is left to the lease, because neither mtime nor rename can
+// compare and swap on a shared folder.
+func (f *FileBackend) CreateObject(dst string, content []byte) error {
+	path, err := f.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := writeTempFile(filepath.Dir(path), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = os.Link(tmp, path)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+	}
+	if err == nil {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+		}
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FileBackend) List(prefix string) ([]ObjectInfo, error) {
+	root, err := f.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// A prefix that names a single file has no children.
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return []ObjectInfo{}, nil
+	}
+
+	objects := make([]ObjectInfo, 0)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), fileTempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, f.objectInfo(filepath.ToSlash(rel), info))
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []ObjectInfo{}, nil
+		}
+		return nil, fmt.Errorf("list failed while walking directory %s: %w", root, err)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (f *FileBackend) Delete(path string) error {
+	localPath, err := f.parseUri(path)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		return fmt.Errorf("delete failed while removing %s: %w", localPath, err)
+	}
+	return nil
+}
+
+func (f *FileBackend) Stat(path string) (ObjectInfo, error) {
+	localPath, err := f.parseUri(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+		}
+		return ObjectInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	return f.objectInfo(localPath, info), nil
+}
+
+func (f *FileBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	path, err := f.parseUri(src)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(path, dst); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+		}
+		return fmt.Errorf("pull failed while copying file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *FileBackend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	path, err := f.parseUri(src)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("pull failed while copying file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *FileBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	path, err := f.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(src, path); err != nil {
+		return fmt.Errorf("push failed while copying file to %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *FileBackend) objectInfo(path string, info os.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Path:    path,
+		Size:    info.Size(),
+		ETag:    fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		ModTime: info.ModTime(),
+	}
+}
+
+func (f *FileBackend) parseUri(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "file://") {
+		return "", fmt.Errorf("invalid file path because the scheme is not file://. Path: %s", raw)
+	}
+	path := strings.TrimPrefix(raw, "file://")
+	if path == "" {
+		return "", fmt.Errorf("invalid file path because the directory is missing. Path: %s", raw)
+	}
+	if !strings.HasPrefix(path, "~") && !strings.HasPrefix(path, "$HOME") && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	expanded, err := expandPath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(filepath.FromSlash(expanded)), nil
+}
+
+func copyFile(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	return writeFileAtomically(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, srcFile)
+		return err
+	})
+}
+
+// writeFileAtomically writes path through a temporary file next to it, so the
+// file is either complete or left as it was.
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := writeTempFile(filepath.Dir(path), write)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func writeTempFile(dir string, write func(w io.Writer) error) (string, error) {
+	file, err := os.CreateTemp(dir, fileTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	tmp := file.Name()
+	err = file.Chmod(0o644)
+	if err == nil {
+		err = write(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
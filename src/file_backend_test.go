@@ -0,0 +1,94 @@
+package src
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFileBackend(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileBackend(OSSConfig{Name: "file"}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "file://" + dir
+
+	testBackendContract(t, f, root)
+
+	if err := f.WriteObject(root+"/donk/cfg/nvim/init.lua", []byte("init")); err != nil {
+		t.Fatal(err)
+	}
+	// A write that crashed leaves its temporary file behind, which List and
+	// therefore every pull skip.
+	if err := os.WriteFile(filepath.Join(dir, "donk", "cfg", "nvim", fileTempPrefix+"123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := f.List(root + "/donk/cfg/nvim")
+	if err != nil || len(objects) != 1 || objects[0].Path != "init.lua" {
+		t.Fatalf("List() = %+v, %v, want init.lua", objects, err)
+	}
+
+	if !conditionalWriteSupported(f, "") || conditionalWriteSupported(f, "etag") {
+		t.Fatal("the file backend should only create objects atomically")
+	}
+
+	lock := root + "/donk/cfg/nvim.lock"
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f.CreateObject(lock, []byte(strings.Repeat("x", i+1)))
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, errPreconditionFailed):
+				t.Errorf("CreateObject() = %v, want %v", err, errPreconditionFailed)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf("%d concurrent CreateObject() calls succeeded, want exactly one", created)
+	}
+
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "donk", "cfg", fileTempPrefix+"*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files were left behind: %v", leftovers)
+	}
+	if err := f.Delete(root + "/donk/cfg/nvim"); err != nil {
+		t.Fatal(err)
+	}
+	if objects, err := f.List(root + "/donk/cfg/nvim"); err != nil || len(objects) != 0 {
+		t.Fatalf("List() after Delete() = %+v, %v, want nothing", objects, err)
+	}
+}
+
+func TestCopyFileLeavesNoTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "out", "dst")
+	if err := copyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(filepath.Join(dir, "missing"), dst); err == nil {
+		t.Fatal("copyFile() of a missing file succeeded")
+	}
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "dst" {
+		t.Fatalf("directory holds %v, %v, want only dst", entries, err)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Mode().Perm() != 0o644 {
+		t.Fatalf("copied file = %v, %v, want mode 0644", info, err)
+	}
+}
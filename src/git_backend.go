@@ -0,0 +1,630 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const gitBackendProvider = "git"
+
+type GitBackend struct {
+	repo   string
+	dir    string
+	branch string
+	synced bool
+	opts   BackendOptions
+	// clone is the cache clone while a transaction works in its own worktree
+	// at dir. It is empty outside of transactions.
+	clone string
+	// scratch is the temporary directory of a dry run clone, and depth counts
+	// the operations running on it.
+	scratch string
+	depth   int
+	// guards maps the files written with WriteObjectIfMatch to the blob they
+	// replace, until the next push.
+	guards map[string]string
+}
+
+func init() {
+	factory := func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewGitBackend(cfg, opts)
+	}
+	RegisterBackend("git+ssh", gitBackendProvider, factory)
+	RegisterBackend("git+https", "", factory)
+	RegisterBackend("git+http", "", factory)
+	RegisterBackend("git+file", "", factory)
+	remoteRoots[gitBackendProvider] = gitRemoteRoot
+}
+
+// gitRemoteRoot turns the bucket of a git remote into a repository path. The
+// bucket is a repository url, a git+<transport> url, or the path of a local
+// repository. A repository whose name does not end in .git gets the "//"
+// separator, so paths below it can be told apart from the repository.
+func gitRemoteRoot(bucket string) (string, error) {
+	repo := strings.TrimRight(strings.TrimSpace(bucket), "/")
+	switch {
+	case repo == "":
+		return "", errors.New("the storage bucket is empty")
+	case strings.HasPrefix(repo, "git+"):
+	case strings.Contains(repo, "://"):
+		repo = "git+" + repo
+	case strings.HasPrefix(repo, "/") || strings.HasPrefix(repo, "~") || strings.HasPrefix(repo, "$HOME"):
+		repo = "git+file://" + repo
+	default:
+		repo = "git+ssh://" + repo
+	}
+	if !strings.HasSuffix(repo, ".git") {
+		repo += "//"
+	}
+	return repo, nil
+}
+
+func NewGitBackend(cfg OSSConfig, opts BackendOptions) (*GitBackend, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("failed to initialize git backend because the git executable was not found in PATH")
+	}
+	return &GitBackend{opts: opts}, nil
+}
+
+func (g *GitBackend) Pull(src string, dst string) error {
+	_, end, err := g.begin(src)
+	if err != nil {
+		return err
+	}
+	defer end()
+	return pullTree(g, g.opts, src, dst)
+}
+
+func (g *GitBackend) Push(src string, dst string) error {
+	path, end, err := g.begin(dst)
+	if err != nil {
+		return err
+	}
+	defer end()
+	stat, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("push failed because the local source path is unavailable: %s", src)
+	}
+
+	if err := g.removeUnreserved(path); err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		err = filepath.WalkDir(src, func(localPath string, d fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(src, localPath)
+			if err != nil {
+				return err
+			}
+			return copyFile(localPath, filepath.Join(path, rel))
+		})
+	} else {
+		err = copyFile(src, path)
+	}
+	if err != nil {
+		return fmt.Errorf("push failed while copying files into git work tree: %w", err)
+	}
+	return g.commit(path, "push")
+}
+
+func (g *GitBackend) ReadObject(src string) ([]byte, error) {
+	path, end, err := g.begin(src)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	if _, err := g.Stat(src); err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading git file %s: %w", path, err)
+	}
+	return content, nil
+}
+
+func (g *GitBackend) WriteObject(dst string, content []byte) error {
+	path, end, err := g.begin(dst)
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	return g.commit(path, "write")
+}
+
+// WriteObjectIfMatch writes dst when its blob is still etag. The blob is
+// checked again against every remote branch tip the write is pushed onto, and
+// the push itself only succeeds as a fast-forward, so no other push can slip
+// in between.
+func (g *GitBackend) WriteObjectIfMatch(dst string, content []byte, etag string) error {
+	path, end, err := g.begin(dst)
+	if err != nil {
+		return err
+	}
+	defer end()
+	current := ""
+	if info, err := g.Stat(dst); err == nil {
+		current = info.ETag
+	} else if !errors.Is(err, errRemoteNotFound) {
+		return err
+	}
+	if current != etag {
+		return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+	}
+	rel, err := filepath.Rel(g.dir, path)
+	if err != nil {
+		return err
+	}
+	if g.guards == nil {
+		g.guards = map[string]string{}
+	}
+	g.guards[filepath.ToSlash(rel)] = etag
+	return g.WriteObject(dst, content)
+}
+
+func (g *GitBackend) List(prefix string) ([]ObjectInfo, error) {
+	root, end, err := g.begin(prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		return []ObjectInfo{}, nil
+	}
+
+	objects := make([]ObjectInfo, 0)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []ObjectInfo{}, nil
+		}
+		return nil, fmt.Errorf("list failed while walking git work tree %s: %w", root, err)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (g *GitBackend) Delete(path string) error {
+	localPath, end, err := g.begin(path)
+	if err != nil {
+		return err
+	}
+	defer end()
+	if _, err := os.Lstat(localPath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err := os.RemoveAll(localPath); err != nil {
+		return fmt.Errorf("delete failed while removing %s: %w", localPath, err)
+	}
+	return g.commit(localPath, "delete")
+}
+
+func (g *GitBackend) Stat(path string) (ObjectInfo, error) {
+	localPath, end, err := g.begin(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer end()
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+		}
+		return ObjectInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	rel, err := filepath.Rel(g.dir, localPath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// The staged blob, so files written earlier in a transaction have one too.
+	etag, _ := g.git("rev-parse", ":"+filepath.ToSlash(rel))
+	return ObjectInfo{
+		Path:    filepath.ToSlash(rel),
+		Size:    info.Size(),
+		ETag:    strings.TrimSpace(etag),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (g *GitBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	path, end, err := g.begin(src)
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := copyFile(path, dst); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+		}
+		return fmt.Errorf("pull failed while copying git file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (g *GitBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	path, end, err := g.begin(dst)
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := copyFile(src, path); err != nil {
+		return fmt.Errorf("push failed while copying files into git work tree: %w", err)
+	}
+	return g.commit(path, "push")
+}
+
+// begin opens the repository for one operation and returns the local path of
+// uri and the function that ends the operation.
+func (g *GitBackend) begin(uri string) (string, func(), error) {
+	path, err := g.openPath(uri)
+	if err != nil {
+		g.removeScratch()
+		return "", nil, err
+	}
+	g.depth++
+	return path, g.end, nil
+}
+
+// end removes the scratch clone of a dry run once the outermost operation on
+// it returns, so a dry run leaves nothing behind.
+func (g *GitBackend) end() {
+	g.depth--
+	if g.depth == 0 {
+		g.removeScratch()
+	}
+}
+
+func (g *GitBackend) removeScratch() {
+	if g.scratch == "" || g.depth > 0 {
+		return
+	}
+	if err := os.RemoveAll(g.scratch); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to remove dry run clone %s: %v\n", g.scratch, err)
+	}
+	g.scratch, g.dir, g.synced = "", "", false
+}
+
+// open makes sure the local cache clone of the repository is up to date with
+// the remote branch. The clone is fetched once per backend instance. A dry run
+// leaves the cache alone and clones into a private temporary directory
+// instead, which begin and end remove again.
+func (g *GitBackend) open(uri string) error {
+	repo, _, err := g.parseUri(uri)
+	if err != nil {
+		return err
+	}
+	if g.synced {
+		if repo != g.repo {
+			return fmt.Errorf("invalid git path because it points to a different repository. Path: %s. Repository: %s", uri, g.repo)
+		}
+		return nil
+	}
+
+	g.repo = repo
+	if g.opts.DryRun {
+		scratch, err := os.MkdirTemp("", "donk-dry-run-")
+		if err != nil {
+			return err
+		}
+		g.scratch, g.dir = scratch, filepath.Join(scratch, "repo")
+	} else {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(repo))
+		g.dir = filepath.Join(cacheDir, "donk", "git", hex.EncodeToString(hash[:8]))
+	}
+
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(g.dir), 0o755); err != nil {
+			return err
+		}
+		if _, err := g.gitIn("", "clone", "--quiet", repo, g.dir); err != nil {
+			return fmt.Errorf("failed to clone git repository %s: %w", repo, err)
+		}
+	} else if err != nil {
+		return err
+	} else if _, err := g.git("fetch", "--quiet", "--prune", "origin"); err != nil {
+		return fmt.Errorf("failed to fetch git repository %s: %w", repo, err)
+	}
+
+	branch, err := g.git("symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to resolve the current branch of git repository %s: %w", repo, err)
+	}
+	g.branch = strings.TrimSpace(branch)
+	if g.hasRemoteBranch() {
+		if _, err := g.git("merge", "--ff-only", "--quiet", "origin/"+g.branch); err != nil {
+			return fmt.Errorf("failed to fast-forward git repository %s: %w", repo, err)
+		}
+	}
+	g.synced = true
+	return nil
+}
+
+func (g *GitBackend) openPath(uri string) (string, error) {
+	if err := g.open(uri); err != nil {
+		return "", err
+	}
+	_, rel, err := g.parseUri(uri)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(g.dir, filepath.FromSlash(rel)), nil
+}
+
+// maxJobs keeps transfers sequential because every write stages into the same
+// work tree.
+func (g *GitBackend) maxJobs() int {
+	return 1
+}
+
+// removeUnreserved clears path for a fresh copy but keeps donk metadata files.
+func (g *GitBackend) removeUnreserved(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+	return filepath.WalkDir(path, func(localPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if strings.HasPrefix(d.Name(), reservedNamePrefix) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return os.Remove(localPath)
+	})
+}
+
+// commit publishes the change at path as one commit. Inside a transaction the
+// change is only staged until commitTransaction.
+func (g *GitBackend) commit(path string, action string) error {
+	rel, err := filepath.Rel(g.dir, path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if _, err := g.git("add", "--all", "--", rel); err != nil {
+		return fmt.Errorf("failed to stage git changes for %s: %w", rel, err)
+	}
+	if g.clone != "" {
+		return nil
+	}
+	if _, err := g.git("diff", "--cached", "--quiet"); err == nil {
+		g.guards = nil
+		return nil
+	}
+	if _, err := g.git("commit", "--quiet", "-m", fmt.Sprintf("donk: %s %s", action, rel)); err != nil {
+		return fmt.Errorf("failed to commit git changes for %s: %w", rel, err)
+	}
+	if err := g.pushCommits(); err != nil {
+		// Drop the local commit so the next fetch can fast-forward again.
+		if g.hasRemoteBranch() {
+			_, _ = g.git("reset", "--hard", "--quiet", "origin/"+g.branch)
+		}
+		g.synced = false
+		return fmt.Errorf("failed to push git changes for %s to %s: %w", rel, g.repo, err)
+	}
+	return nil
+}
+
+// pushCommits pushes the local commits to the remote branch. When another
+// writer pushed first, the commits are rebased onto the new remote branch and
+// pushed again, which fails with errPreconditionFailed when both changed the
+// same file or the other writer changed a file written with
+// WriteObjectIfMatch.
+func (g *GitBackend) pushCommits() error {
+	defer func() { g.guards = nil }()
+	for attempt := 1; ; attempt++ {
+		_, err := g.git("push", "--quiet", "origin", "HEAD:refs/heads/"+g.branch)
+		if err == nil || attempt >= cfgManifestMaxAttempts {
+			return err
+		}
+		if _, err := g.git("fetch", "--quiet", "origin"); err != nil {
+			return err
+		}
+		for rel, etag := range g.guards {
+			current, _ := g.git("rev-parse", "--verify", "--quiet", "origin/"+g.branch+":"+rel)
+			if strings.TrimSpace(current) != etag {
+				return fmt.Errorf("%w. Another writer changed %s", errPreconditionFailed, rel)
+			}
+		}
+		if _, err := g.git("rebase", "--quiet", "origin/"+g.branch); err != nil {
+			_, _ = g.git("rebase", "--abort")
+			return fmt.Errorf("%w. Details: %v", errPreconditionFailed, err)
+		}
+	}
+}
+
+// beginTransaction moves the backend into a worktree of its own at the tip
+// of the remote branch, so writes of other backends on the cache clone never
+// mix with the staged changes.
+func (g *GitBackend) beginTransaction(root string) error {
+	if g.clone != "" {
+		return errors.New("failed to begin git transaction because one is already running")
+	}
+	if err := g.open(root); err != nil {
+		return err
+	}
+	_, _ = g.git("worktree", "prune")
+	dir, err := os.MkdirTemp(filepath.Dir(g.dir), filepath.Base(g.dir)+"-tx-")
+	if err != nil {
+		return err
+	}
+	if g.hasRemoteBranch() {
+		_, err = g.git("worktree", "add", "--quiet", "--detach", dir, "origin/"+g.branch)
+	} else {
+		// An empty repository has no commit to add a worktree at, so the
+		// first push starts from a repository of its own with the same origin.
+		_, err = g.gitIn(dir, "init", "--quiet")
+		if err == nil {
+			_, err = g.gitIn(dir, "symbolic-ref", "HEAD", "refs/heads/"+g.branch)
+		}
+		if err == nil {
+			_, err = g.gitIn(dir, "remote", "add", "origin", g.repo)
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return fmt.Errorf("failed to begin git transaction in %s: %w", g.repo, err)
+	}
+	g.clone, g.dir = g.dir, dir
+	return nil
+}
+
+func (g *GitBackend) commitTransaction(message string) error {
+	if g.clone == "" {
+		return errors.New("failed to commit git transaction because none is running")
+	}
+	defer g.abortTransaction()
+	if _, err := g.git("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if _, err := g.git("commit", "--quiet", "-m", message); err != nil {
+		return fmt.Errorf("failed to commit git changes: %w", err)
+	}
+	if err := g.pushCommits(); err != nil {
+		return fmt.Errorf("failed to push git changes to %s: %w", g.repo, err)
+	}
+	return nil
+}
+
+func (g *GitBackend) abortTransaction() {
+	g.guards = nil
+	if g.clone == "" {
+		return
+	}
+	dir := g.dir
+	g.dir, g.clone = g.clone, ""
+	if _, err := g.git("worktree", "remove", "--force", dir); err != nil {
+		_ = os.RemoveAll(dir)
+		_, _ = g.git("worktree", "prune")
+	}
+}
+
+func (g *GitBackend) hasRemoteBranch() bool {
+	_, err := g.git("rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+g.branch)
+	return err == nil
+}
+
+func (g *GitBackend) git(args ...string) (string, error) {
+	return g.gitIn(g.dir, args...)
+}
+
+func (g *GitBackend) gitIn(dir string, args ...string) (string, error) {
+	user, email := updatedByIdentity()
+	cmd := exec.CommandContext(g.opts.ctx(), "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME="+user,
+		"GIT_AUTHOR_EMAIL="+email,
+		"GIT_COMMITTER_NAME="+user,
+		"GIT_COMMITTER_EMAIL="+email,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		details := strings.TrimSpace(stderr.String())
+		if details == "" {
+			return "", err
+		}
+		return "", fmt.Errorf("%w. Details: %s", err, details)
+	}
+	return stdout.String(), nil
+}
+
+// parseUri splits a git path into the repository url and the path inside the
+// repository. The two parts are separated by "//" or by the first ".git/".
+func (g *GitBackend) parseUri(raw string) (string, string, error) {
+	if !strings.HasPrefix(raw, "git+") {
+		return "", "", fmt.Errorf("invalid git path because the scheme is not git+<transport>://. Path: %s", raw)
+	}
+	withoutPrefix := strings.TrimPrefix(raw, "git+")
+	schemeEnd := strings.Index(withoutPrefix, "://")
+	if schemeEnd <= 0 {
+		return "", "", fmt.Errorf("invalid git path because the transport is missing. Path: %s", raw)
+	}
+	rest := withoutPrefix[schemeEnd+3:]
+
+	repoEnd, relStart := len(rest), len(rest)
+	if idx := strings.Index(rest, "//"); idx > 0 {
+		repoEnd, relStart = idx, idx+2
+	} else if idx := strings.Index(rest, ".git/"); idx > 0 {
+		repoEnd, relStart = idx+len(".git"), idx+len(".git/")
+	}
+	repo := withoutPrefix[:schemeEnd+3] + rest[:repoEnd]
+	rel := strings.Trim(rest[relStart:], "/")
+	if strings.HasPrefix(repo, "file://") {
+		path, err := expandPath(strings.TrimPrefix(repo, "file://"))
+		if err != nil {
+			return "", "", err
+		}
+		repo = path
+	}
+	if rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return "", "", fmt.Errorf("invalid git path because it points into the .git directory. Path: %s", raw)
+	}
+	return repo, rel, nil
+}
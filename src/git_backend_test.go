@@ -0,0 +1,207 @@
+package src
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitRemoteRoot(t *testing.T) {
+	tests := []struct {
+		bucket string
+		want   string
+	}{
+		{bucket: "/srv/donk.git", want: "git+file:///srv/donk.git"},
+		{bucket: "/srv/donk/", want: "git+file:///srv/donk//"},
+		{bucket: "file:///srv/donk.git", want: "git+file:///srv/donk.git"},
+		{bucket: "git+file:///srv/donk.git", want: "git+file:///srv/donk.git"},
+		{bucket: "https://example.com/me/dotfiles.git", want: "git+https://example.com/me/dotfiles.git"},
+		{bucket: "git@example.com/me/dotfiles", want: "git+ssh://git@example.com/me/dotfiles//"},
+	}
+	for _, tt := range tests {
+		got, err := gitRemoteRoot(tt.bucket)
+		if err != nil || got != tt.want {
+			t.Fatalf("gitRemoteRoot(%q) = %s, %v, want %s", tt.bucket, got, err, tt.want)
+		}
+	}
+
+	settings := Settings{OSS: OSSConfig{Name: "git", Bucket: "/srv/dotfiles"}}
+	root, err := settings.entryRemoteRoot(ConfigEntry{Name: "nvim", OSS: "git+file:///srv/dotfiles//donk/cfg/nvim"})
+	if err != nil || root != "git+file:///srv/dotfiles//" {
+		t.Fatalf("entryRemoteRoot() = %s, %v, want git+file:///srv/dotfiles//", root, err)
+	}
+	repo, rel, err := (&GitBackend{}).parseUri(joinRemotePath(root, "donk/cfg/nvim.lock"))
+	if err != nil || repo != "/srv/dotfiles" || rel != "donk/cfg/nvim.lock" {
+		t.Fatalf("parseUri() = %s, %s, %v, want /srv/dotfiles, donk/cfg/nvim.lock", repo, rel, err)
+	}
+}
+
+func TestGitBackend(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	bare := filepath.Join(t.TempDir(), "donk.git")
+	runGit(t, "", "init", "--quiet", "--bare", bare)
+	g, err := NewGitBackend(OSSConfig{Name: "git"}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackendContract(t, g, "git+file://"+bare+"/")
+}
+
+func TestGitBackendCfgPushIsOneCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	bare := filepath.Join(t.TempDir(), "donk.git")
+	runGit(t, "", "init", "--quiet", "--bare", bare)
+
+	settings := Settings{OSS: OSSConfig{Name: "git", Bucket: bare}}
+	settings.Cfg = []ConfigEntry{{Name: "nvim", OSS: "git+file://" + bare + "/donk/cfg/nvim", Link: []string{filepath.Join(t.TempDir(), "nvim")}}}
+	home := t.TempDir()
+	dir := filepath.Join(home, "cfg", "nvim")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := CreateCfgCmd(Context{Dir: home, Settings: settings})
+	for revision, content := range []string{"first", "second"} {
+		if err := os.WriteFile(filepath.Join(dir, "init.lua"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, content+".lua"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Push("nvim", CfgStrategyNone); err != nil {
+			t.Fatalf("push of revision %d: %v", revision+1, err)
+		}
+	}
+
+	// Each push is a single commit, and the lease stays out of the branch.
+	if count := strings.TrimSpace(runGit(t, bare, "rev-list", "--count", "HEAD")); count != "2" {
+		t.Fatalf("git rev-list --count = %s, want 2", count)
+	}
+	if log := runGit(t, bare, "log", "--format=%s"); log != "donk: push nvim\ndonk: push nvim\n" {
+		t.Fatalf("git log =\n%s\nwant two push commits", log)
+	}
+	if files := runGit(t, bare, "ls-tree", "-r", "--name-only", "HEAD", "donk/cfg"); strings.Contains(files, ".lock") {
+		t.Fatalf("the branch holds a lock file:\n%s", files)
+	}
+
+	if worktrees, _ := filepath.Glob(filepath.Join(cache, "donk", "git", "*-tx-*")); len(worktrees) > 0 {
+		t.Fatalf("transaction worktrees were left behind: %v", worktrees)
+	}
+
+	other := t.TempDir()
+	if err := CreateCfgCmd(Context{Dir: other, Settings: settings}).Pull("nvim", CfgStrategyNone); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"init.lua": "second", "first.lua": "first", "second.lua": "second"} {
+		content, err := os.ReadFile(filepath.Join(other, "cfg", "nvim", name))
+		if err != nil || string(content) != want {
+			t.Fatalf("pulled %s = %q, %v, want %q", name, content, err, want)
+		}
+	}
+}
+
+func TestGitBackendWriteObjectIfMatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	bare := filepath.Join(t.TempDir(), "donk.git")
+	runGit(t, "", "init", "--quiet", "--bare", bare)
+	seed := t.TempDir()
+	runGit(t, "", "clone", "--quiet", bare, seed)
+	runGit(t, seed, "-c", "user.name=me", "-c", "user.email=me@example.com", "commit", "--quiet", "--allow-empty", "-m", "init")
+	runGit(t, seed, "push", "--quiet", "origin", "HEAD")
+	manifest := "git+file://" + bare + "//donk/cfg/nvim/.donk-manifest.json"
+
+	// Each backend gets its own cache clone, like two machines would.
+	open := func() *GitBackend {
+		t.Setenv("XDG_CACHE_HOME", t.TempDir())
+		g, err := NewGitBackend(OSSConfig{Name: "git"}, BackendOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.Stat(manifest); !errors.Is(err, errRemoteNotFound) {
+			t.Fatalf("Stat() = %v, want %v", err, errRemoteNotFound)
+		}
+		return g
+	}
+	first, second := open(), open()
+
+	if err := first.WriteObjectIfMatch(manifest, []byte("1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteObjectIfMatch(manifest, []byte("1"), ""); !errors.Is(err, errPreconditionFailed) {
+		t.Fatalf("WriteObjectIfMatch() of an existing file = %v, want %v", err, errPreconditionFailed)
+	}
+	// The second clone still sees no manifest, so only the push notices the
+	// write of the first one.
+	if err := second.WriteObjectIfMatch(manifest, []byte("one"), ""); !errors.Is(err, errPreconditionFailed) {
+		t.Fatalf("WriteObjectIfMatch() behind another push = %v, want %v", err, errPreconditionFailed)
+	}
+	info, err := first.Stat(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteObjectIfMatch(manifest, []byte("2"), info.ETag); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteObjectIfMatch(manifest, []byte("3"), info.ETag); !errors.Is(err, errPreconditionFailed) {
+		t.Fatalf("WriteObjectIfMatch() with a stale etag = %v, want %v", err, errPreconditionFailed)
+	}
+	if content := runGit(t, bare, "show", "HEAD:donk/cfg/nvim/.donk-manifest.json"); content != "2" {
+		t.Fatalf("remote manifest = %q, want 2", content)
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func TestGitBackendDryRunLeavesCacheAlone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	bare := filepath.Join(t.TempDir(), "donk.git")
+	runGit(t, "", "init", "--quiet", "--bare", bare)
+	settings := Settings{OSS: OSSConfig{Name: "git", Bucket: bare}}
+	settings.Cfg = []ConfigEntry{{Name: "nvim", OSS: "git+file://" + bare + "/donk/cfg/nvim", Link: []string{filepath.Join(t.TempDir(), "nvim")}}}
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "cfg", "nvim"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "cfg", "nvim", "init.lua"), []byte("init"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateCfgCmd(Context{Dir: home, Settings: settings}).Push("nvim", CfgStrategyNone); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, tmp := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("TMPDIR", tmp)
+	if err := CreateCfgCmd(Context{Dir: t.TempDir(), Settings: settings, DryRun: true}).Pull("nvim", CfgStrategyNone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cache, "donk", "git")); !os.IsNotExist(err) {
+		t.Fatalf("dry run pull touched the git cache: %v", err)
+	}
+	if left, _ := os.ReadDir(tmp); len(left) > 0 {
+		t.Fatalf("dry run pull left %s behind in the temp directory", left[0].Name())
+	}
+}
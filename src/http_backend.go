@@ -0,0 +1,143 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errHTTPReadOnly = errors.New("the http backend is read-only")
+
+type HTTPBackend struct {
+	client *http.Client
+	opts   BackendOptions
+}
+
+func init() {
+	factory := func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewHTTPBackend(cfg, opts)
+	}
+	RegisterBackend("https", "", factory)
+	RegisterBackend("http", "", factory)
+}
+
+func NewHTTPBackend(cfg OSSConfig, opts BackendOptions) (*HTTPBackend, error) {
+	return &HTTPBackend{client: http.DefaultClient, opts: opts}, nil
+}
+
+func (h *HTTPBackend) Pull(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return h.downloadFile(h.opts.ctx(), src, dst)
+}
+
+func (h *HTTPBackend) Push(src string, dst string) error {
+	return fmt.Errorf("push failed because %w. Destination path: %s", errHTTPReadOnly, dst)
+}
+
+func (h *HTTPBackend) ReadObject(src string) ([]byte, error) {
+	resp, err := h.get(h.opts.ctx(), http.MethodGet, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while downloading %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while downloading %s: %w", src, err)
+	}
+	return content, nil
+}
+
+func (h *HTTPBackend) WriteObject(dst string, content []byte) error {
+	return fmt.Errorf("write failed because %w. Destination path: %s", errHTTPReadOnly, dst)
+}
+
+// List finds nothing, since plain http has no way to list a directory. A
+// missing url then reads as not found rather than as a listing failure.
+func (h *HTTPBackend) List(prefix string) ([]ObjectInfo, error) {
+	return []ObjectInfo{}, nil
+}
+
+func (h *HTTPBackend) Delete(path string) error {
+	return fmt.Errorf("delete failed because %w. Path: %s", errHTTPReadOnly, path)
+}
+
+func (h *HTTPBackend) Stat(path string) (ObjectInfo, error) {
+	resp, err := h.get(h.opts.ctx(), http.MethodHead, path, nil)
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return ObjectInfo{}, err
+		}
+		return ObjectInfo{}, fmt.Errorf("stat failed while requesting %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Path:    path,
+		Size:    resp.ContentLength,
+		ETag:    strings.Trim(resp.Header.Get("ETag"), "\""),
+		ModTime: modTime,
+	}, nil
+}
+
+func (h *HTTPBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	resp, err := h.get(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return err
+		}
+		return fmt.Errorf("pull failed while downloading %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("pull failed while downloading %s: %w", src, err)
+	}
+	return file.Close()
+}
+
+func (h *HTTPBackend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	resp, err := h.get(ctx, http.MethodGet, src, rangeHeader(offset))
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return err
+		}
+		return fmt.Errorf("pull failed while downloading %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	return copyRangeResponse(resp, offset, w)
+}
+
+func (h *HTTPBackend) get(ctx context.Context, method string, src string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http request failed with status %s", resp.Status)
+	}
+	return resp, nil
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
-const libUsageText = "usage: donk lib pull <name>"
+const libUsageText = "usage: donk lib pull <name> [--jobs <n>] [--dry-run]"
 
 type LibCmd struct {
 	Context Context
@@ -19,9 +21,21 @@ func CreateLibCmd(context Context) LibCmd {
 }
 
 func (l LibCmd) Run(args []string) error {
+	if len(args) < 2 || args[0] != "lib" {
+		return fmt.Errorf("invalid command arguments. %s", libUsageText)
+	}
+	parsed, err := parseCmdArgs(args[2:], []string{"dry-run"}, []string{"jobs"})
+	if err != nil {
+		return fmt.Errorf("invalid command arguments because %w. %s", err, libUsageText)
+	}
+	if err := l.Context.applyJobsFlag(parsed); err != nil {
+		return err
+	}
+	l.Context.DryRun = parsed.has("dry-run")
+
 	switch {
-	case len(args) == 3 && args[0] == "lib" && args[1] == "pull":
-		return l.Pull(args[2])
+	case args[1] == "pull" && len(parsed.positional) == 1:
+		return l.Pull(parsed.positional[0])
 	default:
 		return fmt.Errorf("invalid command arguments. %s", libUsageText)
 	}
@@ -53,10 +67,29 @@ func (l LibCmd) Pull(name string) error {
 		}
 	}
 
+	if l.Context.DryRun {
+		return l.planPull(entry, localLibDir, symlinkPlans)
+	}
+
 	if err := os.MkdirAll(filepath.Dir(localLibDir), 0o755); err != nil {
 		return err
 	}
-	if err := pullSource(l.Context.Settings, entry.OSS, localLibDir); err != nil {
+	// A failed download keeps the staging path so the next run resumes it. A
+	// download that cannot be installed is discarded.
+	stagingPath := localLibDir + ".download"
+	if err := pullResumable(l.Context, entry.OSS, stagingPath); err != nil {
+		// The download state is only written once the remote objects were
+		// found, so without it there is nothing to resume.
+		if _, statErr := os.Stat(stagingPath + ".json"); statErr != nil || errors.Is(err, errRemoteNotFound) {
+			return fmt.Errorf("library pull failed while downloading %s: %w", entry.OSS, err)
+		}
+		return fmt.Errorf("library pull failed while downloading %s: %w. Run the same command again to resume the download", entry.OSS, err)
+	}
+	if err := l.installStaging(entry, stagingPath, localLibDir); err != nil {
+		_ = removeStaging(stagingPath)
+		return err
+	}
+	if err := removeStaging(stagingPath); err != nil {
 		return err
 	}
 
@@ -64,6 +97,87 @@ func (l LibCmd) Pull(name string) error {
 		return err
 	}
 
+	finishProgress(l.Context.Progress)
 	fmt.Printf("library pull completed successfully for: %s\n", name)
 	return nil
 }
+
+// planPull prints what Pull would download and install without touching disk.
+func (l LibCmd) planPull(entry ConfigEntry, localLibDir string, symlinkPlans []SymlinkPlan) error {
+	backend, err := l.Context.openBackend(entry.OSS)
+	if err != nil {
+		return err
+	}
+	objects, single, err := listDownload(backend, entry.OSS)
+	if err != nil {
+		return fmt.Errorf("library pull failed while listing %s: %w", entry.OSS, err)
+	}
+
+	plan := &dryRunPlan{}
+	stagingPath := localLibDir + ".download"
+	if _, err := os.Stat(stagingPath + ".json"); err == nil {
+		plan.add("resume", "the download staged at %s when the remote is unchanged", stagingPath)
+	}
+	for _, obj := range objects {
+		src := entry.OSS
+		if !single {
+			src = joinRemotePath(entry.OSS, obj.Path)
+		}
+		plan.add("download", "%s (%s)", src, formatBytes(obj.Size))
+	}
+	if entry.SHA256 != "" {
+		plan.add("verify", "sha256 %s", entry.SHA256)
+	}
+	if archiveName := strings.SplitN(entry.OSS, "?", 2)[0]; single && isArchivePath(archiveName) {
+		plan.add("extract", "%s to %s", path.Base(archiveName), localLibDir)
+	} else {
+		plan.add("install", "%s", localLibDir)
+	}
+	plan.addSymlinks(symlinkPlans)
+	plan.print(fmt.Sprintf("library pull dry run for: %s. Nothing was changed", entry.Name))
+	return nil
+}
+
+// installStaging verifies the downloaded library against the expected sha256
+// and moves it to the local library directory, unpacking it when the remote
+// path names an archive.
+func (l LibCmd) installStaging(entry ConfigEntry, stagingPath string, localLibDir string) error {
+	info, err := os.Stat(stagingPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if entry.SHA256 != "" {
+			return fmt.Errorf("library pull failed because sha256 can only be verified for a single file, but the remote path is a directory: %s", entry.OSS)
+		}
+		return os.Rename(stagingPath, localLibDir)
+	}
+
+	if entry.SHA256 != "" {
+		actual, err := fileSHA256(stagingPath)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(actual, strings.TrimSpace(entry.SHA256)) {
+			return fmt.Errorf("library pull failed because the sha256 does not match. Expected: %s. Actual: %s", entry.SHA256, actual)
+		}
+	}
+
+	archiveName := strings.SplitN(entry.OSS, "?", 2)[0]
+	if !isArchivePath(archiveName) {
+		return os.Rename(stagingPath, localLibDir)
+	}
+	tmpLibDir := localLibDir + ".tmp"
+	if err := os.RemoveAll(tmpLibDir); err != nil {
+		return err
+	}
+	if err := extractArchive(stagingPath, archiveName, tmpLibDir); err != nil {
+		_ = os.RemoveAll(tmpLibDir)
+		return fmt.Errorf("library pull failed while extracting archive: %w", err)
+	}
+	if err := os.Rename(tmpLibDir, localLibDir); err != nil {
+		_ = os.RemoveAll(tmpLibDir)
+		return err
+	}
+	return os.Remove(stagingPath)
+}
@@ -0,0 +1,321 @@
+package src
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLibPull(t *testing.T) {
+	content := "tool\n"
+	hash := sha256.Sum256([]byte(content))
+	tests := []struct {
+		name    string
+		oss     string
+		sha256  string
+		setup   func(dir string, link string)
+		dryRun  bool
+		wantErr string
+		want    map[string]string
+	}{
+		{
+			name: "single file",
+			oss:  "oss://team/donk/lib/tool",
+			want: map[string]string{"": content},
+		},
+		{
+			name:   "single file with sha256",
+			oss:    "oss://team/donk/lib/tool",
+			sha256: hex.EncodeToString(hash[:]),
+			want:   map[string]string{"": content},
+		},
+		{
+			name:    "sha256 mismatch",
+			oss:     "oss://team/donk/lib/tool",
+			sha256:  hex.EncodeToString(make([]byte, sha256.Size)),
+			wantErr: "the sha256 does not match",
+		},
+		{
+			name: "directory",
+			oss:  "oss://team/donk/lib/jdk",
+			want: map[string]string{"bin/java": "java\n", "release": "8\n"},
+		},
+		{
+			name:    "directory with sha256",
+			oss:     "oss://team/donk/lib/jdk",
+			sha256:  hex.EncodeToString(hash[:]),
+			wantErr: "sha256 can only be verified for a single file",
+		},
+		{
+			name:    "missing remote path",
+			oss:     "oss://team/donk/lib/missing",
+			wantErr: "library pull failed while downloading oss://team/donk/lib/missing: remote object or directory was not found",
+		},
+		{
+			name: "local library directory exists",
+			oss:  "oss://team/donk/lib/tool",
+			setup: func(dir string, link string) {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "the local library directory already exists",
+		},
+		{
+			name: "link path exists",
+			oss:  "oss://team/donk/lib/tool",
+			setup: func(dir string, link string) {
+				if err := os.WriteFile(link, []byte("occupied"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "the link path already exists",
+		},
+		{
+			name:   "dry run",
+			oss:    "oss://team/donk/lib/tool",
+			dryRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := newMemBackend(BackendOptions{})
+			objects := map[string]string{
+				"donk/lib/tool":         content,
+				"donk/lib/jdk/bin/java": "java\n",
+				"donk/lib/jdk/release":  "8\n",
+			}
+			for key, value := range objects {
+				if err := remote.WriteObject("oss://team/"+key, []byte(value)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			home := t.TempDir()
+			link := filepath.Join(t.TempDir(), "tool")
+			context := Context{
+				Dir: home,
+				Settings: Settings{
+					OSS: OSSConfig{Name: "aliyun-oss", Bucket: "team"},
+					Lib: []ConfigEntry{{Name: "tool", OSS: tt.oss, SHA256: tt.sha256, Link: LinkConfig{link}}},
+				},
+				Backends: map[string]Backend{"oss": remote},
+				DryRun:   tt.dryRun,
+			}
+			dir := filepath.Join(home, "lib", "tool")
+			if tt.setup != nil {
+				tt.setup(dir, link)
+			}
+			err := CreateLibCmd(context).Pull("tool")
+			checkTestErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				// None of these failures goes away by running the pull again.
+				if strings.Contains(err.Error(), "resume") {
+					t.Fatalf("error = %v, want no hint to resume", err)
+				}
+				return
+			}
+			if tt.dryRun {
+				if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+					t.Fatalf("dry run created %s", dir)
+				}
+				if _, err := os.Lstat(link); !os.IsNotExist(err) {
+					t.Fatalf("dry run created %s", link)
+				}
+				return
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(link, filepath.FromSlash(name)))
+				if err != nil || string(got) != want {
+					t.Fatalf("%s behind the link = %q, %v, want %q", name, got, err, want)
+				}
+			}
+			if staged, _ := filepath.Glob(dir + ".download*"); len(staged) > 0 {
+				t.Fatalf("pull left staging files behind: %v", staged)
+			}
+		})
+	}
+}
+
+func TestLibPullHTTP(t *testing.T) {
+	tool := "tool\n"
+	hash := sha256.Sum256([]byte(tool))
+	archive := buildTestArchive(t, "jdk.tgz", []archiveTestEntry{
+		{name: "jdk/bin/java", body: "java\n"},
+		{name: "bin", link: "jdk/bin"},
+	})
+	files := map[string][]byte{"/dist/tool": []byte(tool), "/dist/jdk.tgz": archive}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		sha256  string
+		wantErr string
+		want    map[string]string
+	}{
+		{
+			name:   "single file with sha256",
+			path:   "/dist/tool",
+			sha256: hex.EncodeToString(hash[:]),
+			want:   map[string]string{"": tool},
+		},
+		{
+			name:    "sha256 mismatch",
+			path:    "/dist/tool",
+			sha256:  hex.EncodeToString(make([]byte, sha256.Size)),
+			wantErr: "the sha256 does not match",
+		},
+		{
+			name: "archive",
+			path: "/dist/jdk.tgz",
+			want: map[string]string{"jdk/bin/java": "java\n", "bin/java": "java\n"},
+		},
+		{
+			name:    "missing url",
+			path:    "/dist/missing",
+			wantErr: "remote object or directory was not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			link := filepath.Join(t.TempDir(), "tool")
+			context := Context{
+				Dir: home,
+				Settings: Settings{
+					Lib: []ConfigEntry{{Name: "tool", OSS: server.URL + tt.path, SHA256: tt.sha256, Link: LinkConfig{link}}},
+				},
+			}
+			checkTestErr(t, CreateLibCmd(context).Pull("tool"), tt.wantErr)
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(link, filepath.FromSlash(name)))
+				if err != nil || string(got) != want {
+					t.Fatalf("%s behind the link = %q, %v, want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLibPullResume(t *testing.T) {
+	original := []byte("0123456789abcdef")
+	changed := []byte("fedcba9876543210")
+	tests := []struct {
+		name       string
+		interrupt  func(server *resumeTestServer)
+		want       []byte
+		wantRanges []string
+	}{
+		{
+			name:       "partial download",
+			interrupt:  func(server *resumeTestServer) {},
+			want:       original,
+			wantRanges: []string{"bytes=6-"},
+		},
+		{
+			name: "remote changed between runs",
+			interrupt: func(server *resumeTestServer) {
+				server.content, server.etag = changed, "v2"
+			},
+			want:       changed,
+			wantRanges: []string{""},
+		},
+		{
+			name: "server ignores the range",
+			interrupt: func(server *resumeTestServer) {
+				server.ignoreRange = true
+			},
+			want:       original,
+			wantRanges: []string{"bytes=6-", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &resumeTestServer{content: original, etag: "v1", cutAfter: 6}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			home := t.TempDir()
+			link := filepath.Join(t.TempDir(), "tool")
+			context := Context{
+				Dir: home,
+				Settings: Settings{
+					Lib: []ConfigEntry{{Name: "tool", OSS: httpServer.URL + "/dist/tool", Link: LinkConfig{link}}},
+				},
+			}
+			staging := filepath.Join(home, "lib", "tool.download")
+
+			checkTestErr(t, CreateLibCmd(context).Pull("tool"), "Run the same command again to resume the download")
+			if part, err := os.ReadFile(staging + ".part"); err != nil || string(part) != string(original[:6]) {
+				t.Fatalf("partial download = %q, %v, want %q", part, err, original[:6])
+			}
+
+			server.mu.Lock()
+			tt.interrupt(server)
+			server.ranges = nil
+			server.mu.Unlock()
+			if err := CreateLibCmd(context).Pull("tool"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(link); err != nil || string(got) != string(tt.want) {
+				t.Fatalf("library = %q, %v, want %q", got, err, tt.want)
+			}
+			if !slices.Equal(server.ranges, tt.wantRanges) {
+				t.Fatalf("range headers = %q, want %q", server.ranges, tt.wantRanges)
+			}
+			if staged, _ := filepath.Glob(staging + "*"); len(staged) > 0 {
+				t.Fatalf("pull left staging files behind: %v", staged)
+			}
+		})
+	}
+}
+
+// resumeTestServer serves one file. The first download is cut off after
+// cutAfter bytes, and the Range header of every later download is recorded.
+type resumeTestServer struct {
+	mu          sync.Mutex
+	content     []byte
+	etag        string
+	ignoreRange bool
+	cutAfter    int
+	ranges      []string
+}
+
+func (s *resumeTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("ETag", `"`+s.etag+`"`)
+	if r.Method != http.MethodGet {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+		return
+	}
+	if s.cutAfter > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		_, _ = w.Write(s.content[:s.cutAfter])
+		w.(http.Flusher).Flush()
+		s.cutAfter = 0
+		panic(http.ErrAbortHandler)
+	}
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	if s.ignoreRange {
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+}
@@ -0,0 +1,333 @@
+package src
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const lockUsageText = "usage: donk lock status | donk lock break <name>"
+
+// cfgLockTTL bounds how long a crashed push can block other machines before
+// its lock is considered stale and may be taken over. A running push renews
+// its lock every cfgLockRenewInterval, so it keeps the lock however long it
+// takes.
+const (
+	cfgLockTTL           = 10 * time.Minute
+	cfgLockRenewInterval = cfgLockTTL / 4
+)
+
+var errCfgLeaseLost = errors.New("the remote lock was lost")
+
+type LockCmd struct {
+	Context Context
+}
+
+// CfgLock is the lease stored at donk/cfg/<name>.lock while a push is running.
+type CfgLock struct {
+	Name       string `json:"name"`
+	Holder     string `json:"holder"`
+	Host       string `json:"host"`
+	Token      string `json:"token"`
+	AcquiredAt string `json:"acquired_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+type cfgLease struct {
+	lock     LockCmd
+	entry    ConfigEntry
+	token    string
+	interval time.Duration
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func CreateLockCmd(context Context) LockCmd {
+	return LockCmd{Context: context}
+}
+
+func (l LockCmd) Run(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "lock" && args[1] == "status":
+		return l.Status()
+	case len(args) == 3 && args[0] == "lock" && args[1] == "break":
+		return l.Break(args[2])
+	default:
+		return fmt.Errorf("invalid command arguments. %s", lockUsageText)
+	}
+}
+
+func (l LockCmd) Status() error {
+	if len(l.Context.Settings.Cfg) == 0 {
+		fmt.Println("no cfg entries are defined in settings")
+		return nil
+	}
+	for _, entry := range l.Context.Settings.Cfg {
+		lock, exists, _, err := l.readCfgLock(entry)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !exists:
+			fmt.Printf("%s: unlocked\n", entry.Name)
+		case l.isExpired(lock):
+			fmt.Printf("%s: stale lock held by %s@%s since %s, expired at %s\n", entry.Name, lock.Holder, lock.Host, lock.AcquiredAt, lock.ExpiresAt)
+		default:
+			fmt.Printf("%s: locked by %s@%s since %s, expires at %s\n", entry.Name, lock.Holder, lock.Host, lock.AcquiredAt, lock.ExpiresAt)
+		}
+	}
+	return nil
+}
+
+func (l LockCmd) Break(name string) error {
+	entry, err := findEntry(l.Context.Settings.Cfg, name)
+	if err != nil {
+		return err
+	}
+	lock, exists, _, err := l.readCfgLock(entry)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Printf("lock break was skipped because no lock exists for: %s\n", name)
+		return nil
+	}
+	deleted, err := l.deleteCfgLockIfHeld(entry, lock.Token)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("lock break failed because the lock of %s was released or taken over while it was being broken. Please run donk lock status and try again", name)
+	}
+	fmt.Printf("lock break completed successfully for: %s. Previous holder: %s@%s\n", name, lock.Holder, lock.Host)
+	return nil
+}
+
+// acquireCfgLock takes the push lock of an entry. A lock that expired is taken
+// over with a conditional write, so two machines cannot both win it. Where the
+// backend cannot write conditionally the lock is read back instead, and a
+// machine that still wins a tie loses the lease check before its manifest
+// write.
+func (l LockCmd) acquireCfgLock(entry ConfigEntry) (*cfgLease, error) {
+	current, exists, etag, err := l.readCfgLock(entry)
+	if err != nil {
+		return nil, err
+	}
+	if exists && !l.isExpired(current) {
+		return nil, l.lockedErr("configuration push", entry, current)
+	}
+
+	token, err := l.newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	user, _ := updatedByIdentity()
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	content, err := json.MarshalIndent(CfgLock{
+		Name:       entry.Name,
+		Holder:     user,
+		Host:       host,
+		Token:      token,
+		AcquiredAt: now.Format(time.RFC3339),
+		ExpiresAt:  now.Add(cfgLockTTL).Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	dst, backend, err := l.openCfgLock(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeObjectIfMatch(backend, dst, content, etag); err != nil {
+		if errors.Is(err, errPreconditionFailed) {
+			if winner, ok, _, readErr := l.readCfgLock(entry); readErr == nil && ok {
+				return nil, l.lockedErr("configuration push", entry, winner)
+			}
+		}
+		return nil, fmt.Errorf("configuration push failed while acquiring the remote lock for %s: %w", entry.Name, err)
+	}
+	lease := &cfgLease{lock: l, entry: entry, token: token, interval: cfgLockRenewInterval}
+	// Without an atomic write another machine may have taken the lock at the
+	// same time, and the last write wins.
+	if !conditionalWriteSupported(backend, etag) {
+		if err := lease.verify(); err != nil {
+			if winner, ok, _, readErr := l.readCfgLock(entry); readErr == nil && ok {
+				return nil, l.lockedErr("configuration push", entry, winner)
+			}
+			return nil, fmt.Errorf("configuration push failed while acquiring the remote lock for %s: %w", entry.Name, err)
+		}
+	}
+	return lease, nil
+}
+
+// checkCfgUnlocked fails when another machine is in the middle of a push, so
+// a pull never reads a half written remote prefix.
+func (l LockCmd) checkCfgUnlocked(entry ConfigEntry) error {
+	current, exists, _, err := l.readCfgLock(entry)
+	if err != nil {
+		return err
+	}
+	if exists && !l.isExpired(current) {
+		return l.lockedErr("configuration pull", entry, current)
+	}
+	return nil
+}
+
+// keepAlive renews the lease in the background until release. When a renewal
+// fails, for example because the lock was broken and taken by another machine,
+// cancel is called with errCfgLeaseLost so the push stops before it writes
+// anything else under a lock it no longer holds.
+func (s *cfgLease) keepAlive(cancel context.CancelCauseFunc) {
+	s.stop, s.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := s.renew(); err != nil {
+					cancel(fmt.Errorf("configuration push failed because %w for %s: %w", errCfgLeaseLost, s.entry.Name, err))
+					return
+				}
+			}
+		}
+	}()
+}
+
+// verify fails unless the lock still carries the token of this lease and has
+// not expired.
+func (s *cfgLease) verify() error {
+	current, exists, _, err := s.lock.readCfgLock(s.entry)
+	if err != nil {
+		return err
+	}
+	if !exists || current.Token != s.token {
+		return errors.New("the lock was broken or taken over by another machine")
+	}
+	if s.lock.isExpired(current) {
+		return errors.New("the lock expired")
+	}
+	return nil
+}
+
+// renew moves the expiry of the lock forward, as long as it still carries the
+// token of this lease.
+func (s *cfgLease) renew() error {
+	current, exists, etag, err := s.lock.readCfgLock(s.entry)
+	if err != nil {
+		return err
+	}
+	if !exists || current.Token != s.token {
+		return errors.New("the lock was broken or taken over by another machine")
+	}
+	current.ExpiresAt = time.Now().UTC().Add(cfgLockTTL).Format(time.RFC3339)
+	content, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	dst, backend, err := s.lock.openCfgLock(s.entry)
+	if err != nil {
+		return err
+	}
+	return writeObjectIfMatch(backend, dst, content, etag)
+}
+
+// release stops renewing and removes the lock unless it was broken and taken
+// by someone else. Failures are only reported because the lock expires on its
+// own.
+func (s *cfgLease) release() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+	}
+	if _, err := s.lock.deleteCfgLockIfHeld(s.entry, s.token); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to release remote lock for %s: %v\n", s.entry.Name, err)
+	}
+}
+
+func (l LockCmd) readCfgLock(entry ConfigEntry) (CfgLock, bool, string, error) {
+	src, backend, err := l.openCfgLock(entry)
+	if err != nil {
+		return CfgLock{}, false, "", err
+	}
+	info, err := backend.Stat(src)
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return CfgLock{}, false, "", nil
+		}
+		return CfgLock{}, false, "", err
+	}
+	content, err := backend.ReadObject(src)
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return CfgLock{}, false, "", nil
+		}
+		return CfgLock{}, false, "", err
+	}
+	var lock CfgLock
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return CfgLock{}, false, "", fmt.Errorf("failed to parse remote lock file %s: %w", src, err)
+	}
+	return lock, true, info.ETag, nil
+}
+
+// deleteCfgLockIfHeld removes the lock only while it still carries token, so
+// a lock taken over since it was last read is left to its new holder. No
+// backend can delete conditionally, so the lock is read again right before
+// the delete.
+func (l LockCmd) deleteCfgLockIfHeld(entry ConfigEntry, token string) (bool, error) {
+	current, exists, _, err := l.readCfgLock(entry)
+	if err != nil {
+		return false, err
+	}
+	if !exists || current.Token != token {
+		return false, nil
+	}
+	path, backend, err := l.openCfgLock(entry)
+	if err != nil {
+		return false, err
+	}
+	return true, backend.Delete(path)
+}
+
+func (l LockCmd) openCfgLock(entry ConfigEntry) (string, Backend, error) {
+	path, err := CreateCfgCmd(l.Context).buildRemoteCfgPath(entry, entry.Name+".lock")
+	if err != nil {
+		return "", nil, err
+	}
+	backend, err := l.Context.openBackend(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, backend, nil
+}
+
+func (l LockCmd) isExpired(lock CfgLock) bool {
+	expiresAt, err := time.Parse(time.RFC3339, lock.ExpiresAt)
+	if err != nil {
+		return true
+	}
+	return time.Now().After(expiresAt)
+}
+
+func (l LockCmd) lockedErr(action string, entry ConfigEntry, lock CfgLock) error {
+	return fmt.Errorf("%s failed because %s is locked by %s@%s since %s until %s. Please try again later, or run donk lock break %s if that push was interrupted", action, entry.Name, lock.Holder, lock.Host, lock.AcquiredAt, lock.ExpiresAt, entry.Name)
+}
+
+func (l LockCmd) newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,177 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newLockTestContext(t *testing.T) (Context, ConfigEntry) {
+	t.Helper()
+	root := t.TempDir()
+	entry := ConfigEntry{Name: "nvim", OSS: "file://" + root + "/donk/cfg/nvim"}
+	return Context{Settings: Settings{
+		OSS: OSSConfig{Name: "file", Bucket: root},
+		Cfg: []ConfigEntry{entry},
+	}}, entry
+}
+
+func TestCfgLeaseRenew(t *testing.T) {
+	c, entry := newLockTestContext(t)
+	lock := CreateLockCmd(c)
+	lease, err := lock.acquireCfgLock(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lock.acquireCfgLock(entry); err == nil {
+		t.Fatal("acquireCfgLock() succeeded while the lock is held")
+	}
+	if err := lock.checkCfgUnlocked(entry); err == nil {
+		t.Fatal("checkCfgUnlocked() succeeded while the lock is held")
+	}
+
+	before, _, _, err := lock.readCfgLock(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before.ExpiresAt = time.Now().UTC().Add(time.Minute).Format(time.RFC3339)
+	if err := writeLockForTest(lock, entry, before); err != nil {
+		t.Fatal(err)
+	}
+	if err := lease.renew(); err != nil {
+		t.Fatal(err)
+	}
+	after, _, _, err := lock.readCfgLock(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ExpiresAt <= before.ExpiresAt {
+		t.Fatalf("renew() left the lock expiring at %s, want later than %s", after.ExpiresAt, before.ExpiresAt)
+	}
+
+	lease.release()
+	if err := lock.checkCfgUnlocked(entry); err != nil {
+		t.Fatalf("checkCfgUnlocked() after release = %v", err)
+	}
+}
+
+func TestCfgLeaseKeepAliveCancelsWhenLost(t *testing.T) {
+	c, entry := newLockTestContext(t)
+	lock := CreateLockCmd(c)
+	lease, err := lock.acquireCfgLock(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	lease.interval = 10 * time.Millisecond
+	lease.keepAlive(cancel)
+	defer lease.release()
+
+	if err := lock.Break(entry.Name); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepAlive() did not cancel the push after the lock was broken")
+	}
+	if err := context.Cause(ctx); !errors.Is(err, errCfgLeaseLost) {
+		t.Fatalf("context.Cause() = %v, want %v", err, errCfgLeaseLost)
+	}
+}
+
+func TestLockBreak(t *testing.T) {
+	held := CfgLock{Name: "nvim", Holder: "alice", Host: "laptop", Token: "held", ExpiresAt: time.Now().UTC().Add(time.Minute).Format(time.RFC3339)}
+	next := CfgLock{Name: "nvim", Holder: "bob", Host: "desktop", Token: "next", ExpiresAt: held.ExpiresAt}
+	tests := []struct {
+		name      string
+		lock      *CfgLock
+		takeover  bool
+		wantErr   string
+		wantOut   string
+		wantToken string
+	}{
+		{
+			name:    "no lock",
+			wantOut: "lock break was skipped because no lock exists for: nvim\n",
+		},
+		{
+			name:    "held lock",
+			lock:    &held,
+			wantOut: "lock break completed successfully for: nvim. Previous holder: alice@laptop\n",
+		},
+		{
+			name:      "taken over while breaking",
+			lock:      &held,
+			takeover:  true,
+			wantErr:   "lock break failed because the lock of nvim was released or taken over while it was being broken",
+			wantToken: "next",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, entry := newLockTestContext(t)
+			fileBackend, err := NewFileBackend(OSSConfig{}, BackendOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend := &takeoverBackend{Backend: fileBackend}
+			c.Backends = map[string]Backend{"file": backend}
+			lock := CreateLockCmd(c)
+			if tt.lock != nil {
+				if err := writeLockForTest(lock, entry, *tt.lock); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.takeover {
+				backend.takeover = func() {
+					if err := writeLockForTest(lock, entry, next); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+
+			out, err := captureStdout(t, func() error { return lock.Break(entry.Name) })
+			checkTestErr(t, err, tt.wantErr)
+			if out != tt.wantOut {
+				t.Fatalf("Break() printed %q, want %q", out, tt.wantOut)
+			}
+			current, exists, _, err := lock.readCfgLock(entry)
+			if err != nil || exists != (tt.wantToken != "") || current.Token != tt.wantToken {
+				t.Fatalf("lock after Break() = %+v, %v, %v, want token %q", current, exists, err, tt.wantToken)
+			}
+		})
+	}
+}
+
+// takeoverBackend runs takeover once, right after the lock is first read, as
+// if another machine took the lock over at that moment.
+type takeoverBackend struct {
+	Backend
+	once     sync.Once
+	takeover func()
+}
+
+func (b *takeoverBackend) ReadObject(src string) ([]byte, error) {
+	content, err := b.Backend.ReadObject(src)
+	if b.takeover != nil {
+		b.once.Do(b.takeover)
+	}
+	return content, err
+}
+
+func writeLockForTest(lock LockCmd, entry ConfigEntry, current CfgLock) error {
+	dst, backend, err := lock.openCfgLock(entry)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.WriteObject(dst, content)
+}
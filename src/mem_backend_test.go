@@ -0,0 +1,191 @@
+package src
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemBackend keeps objects in process memory. Tests inject it through
+// Context.Backends in place of a real remote, under any scheme.
+type MemBackend struct {
+	opts    BackendOptions
+	mu      sync.Mutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	content []byte
+	modTime time.Time
+}
+
+func newMemBackend(opts BackendOptions) *MemBackend {
+	return &MemBackend{opts: opts, objects: map[string]memObject{}}
+}
+
+func (m *MemBackend) Pull(src string, dst string) error {
+	return pullTree(m, m.opts, src, dst)
+}
+
+func (m *MemBackend) Push(src string, dst string) error {
+	return pushTree(m, m.opts, src, dst)
+}
+
+func (m *MemBackend) ReadObject(src string) ([]byte, error) {
+	key, err := m.parseUri(src)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	return append([]byte(nil), obj.content...), nil
+}
+
+func (m *MemBackend) WriteObject(dst string, content []byte) error {
+	key, err := m.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memObject{
+		content: append([]byte(nil), content...),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemBackend) WriteObjectIfMatch(dst string, content []byte, etag string) error {
+	key, err := m.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := ""
+	if obj, ok := m.objects[key]; ok {
+		current = m.objectInfo(obj).ETag
+	}
+	if current != etag {
+		return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+	}
+	m.objects[key] = memObject{
+		content: append([]byte(nil), content...),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemBackend) List(prefix string) ([]ObjectInfo, error) {
+	key, err := m.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+	key = strings.TrimSuffix(key, "/") + "/"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objects := make([]ObjectInfo, 0)
+	for objKey, obj := range m.objects {
+		if !strings.HasPrefix(objKey, key) {
+			continue
+		}
+		info := m.objectInfo(obj)
+		info.Path = strings.TrimPrefix(objKey, key)
+		objects = append(objects, info)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (m *MemBackend) Delete(path string) error {
+	key, err := m.parseUri(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for objKey := range m.objects {
+		if objKey == key || strings.HasPrefix(objKey, key+"/") {
+			delete(m.objects, objKey)
+		}
+	}
+	return nil
+}
+
+func (m *MemBackend) Stat(path string) (ObjectInfo, error) {
+	key, err := m.parseUri(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	info := m.objectInfo(obj)
+	info.Path = key
+	return info, nil
+}
+
+func (m *MemBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	content, err := m.ReadObject(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0o644)
+}
+
+func (m *MemBackend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	content, err := m.ReadObject(src)
+	if err != nil {
+		return err
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	_, err = w.Write(content[offset:])
+	return err
+}
+
+func (m *MemBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return m.WriteObject(dst, content)
+}
+
+func (m *MemBackend) objectInfo(obj memObject) ObjectInfo {
+	hash := sha256.Sum256(obj.content)
+	return ObjectInfo{
+		Size:    int64(len(obj.content)),
+		ETag:    hex.EncodeToString(hash[:]),
+		ModTime: obj.modTime,
+	}
+}
+
+// parseUri strips the scheme, so paths of every provider map to the same
+// keys.
+func (m *MemBackend) parseUri(raw string) (string, error) {
+	idx := strings.Index(raw, "://")
+	if idx <= 0 {
+		return "", fmt.Errorf("invalid memory path because the scheme is missing. Path: %s", raw)
+	}
+	key := strings.Trim(raw[idx+len("://"):], "/")
+	if key == "" {
+		return "", fmt.Errorf("invalid memory path because the key is missing. Path: %s", raw)
+	}
+	return key, nil
+}
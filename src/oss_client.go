@@ -2,33 +2,47 @@ package src
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
 type OSSConfig struct {
-	Name      string `json:"name"`
-	AccessKey string `json:"access_key"`
-	SecretKey string `json:"secret_key"`
-	Bucket    string `json:"bucket"`
-	Endpoint  string `json:"endpoint"`
+	Name         string `json:"name"`
+	AccessKey    string `json:"access_key"`
+	SecretKey    string `json:"secret_key"`
+	Bucket       string `json:"bucket"`
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	PathStyle    bool   `json:"path_style"`
+	SessionToken string `json:"session_token"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Token        string `json:"token"`
 }
 
 type OSSClient struct {
 	cfg    OSSConfig
 	bucket *oss.Bucket
+	opts   BackendOptions
 }
 
-var errOSSObjectOrPrefixNotFound = errors.New("remote object or directory was not found in OSS")
+func init() {
+	RegisterBackend("oss", defaultBackendProvider, func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewOSSClient(cfg, opts)
+	})
+}
 
-func NewOSSClient(cfg OSSConfig) (*OSSClient, error) {
-	if cfg.Name != "" && cfg.Name != "aliyun-oss" {
+func NewOSSClient(cfg OSSConfig, opts BackendOptions) (*OSSClient, error) {
+	if cfg.Name != "" && cfg.Name != defaultBackendProvider {
 		return nil, fmt.Errorf("failed to initialize OSS client because the provider is not supported: %s", cfg.Name)
 	}
 	if cfg.AccessKey == "" || cfg.SecretKey == "" || cfg.Endpoint == "" {
@@ -52,161 +66,229 @@ func NewOSSClient(cfg OSSConfig) (*OSSClient, error) {
 	return &OSSClient{
 		cfg:    cfg,
 		bucket: bucket,
+		opts:   opts,
 	}, nil
 }
 
 func (o *OSSClient) Pull(src string, dst string) error {
+	return pullTree(o, o.opts, src, dst)
+}
+
+func (o *OSSClient) Push(src string, dst string) error {
+	return pushTree(o, o.opts, src, dst)
+}
+
+func (o *OSSClient) ReadObject(src string) ([]byte, error) {
 	_, key, err := o.parseUri(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	if key == "" {
-		return errors.New("pull failed because the OSS path is invalid and the object key is missing")
+		return nil, errors.New("read failed because the OSS path is invalid and the object key is missing")
 	}
 
-	// Single object path.
-	exists, err := o.bucket.IsObjectExist(key)
+	exists, err := o.bucket.IsObjectExist(key, o.ctxOption())
 	if err != nil {
-		return fmt.Errorf("pull failed while checking whether the OSS object exists: %w", err)
+		return nil, fmt.Errorf("read failed while checking whether the OSS object exists: %w", err)
 	}
-	if exists {
-		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-			return err
-		}
-		return o.bucket.GetObjectToFile(key, dst)
+	if !exists {
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+
+	reader, err := o.bucket.GetObject(key, o.ctxOption())
+	if err != nil {
+		return nil, fmt.Errorf("read failed while opening OSS object %s: %w", key, err)
 	}
+	defer reader.Close()
 
-	// Prefix path.
-	prefix := key
-	if !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading content from OSS object %s: %w", key, err)
 	}
-	if err := os.RemoveAll(dst); err != nil {
+	return content, nil
+}
+
+func (o *OSSClient) WriteObject(dest string, content []byte) error {
+	_, key, err := o.parseUri(dest)
+	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(dst, 0o755); err != nil {
+	if key == "" {
+		return errors.New("write failed because the OSS path is invalid and the object key is missing")
+	}
+	if err := o.bucket.PutObject(key, bytes.NewReader(content), o.ctxOption()); err != nil {
+		return fmt.Errorf("write failed while uploading OSS object %s: %w", key, err)
+	}
+	return nil
+}
+
+// CreateObject relies on OSS forbid-overwrite, so only one of several
+// concurrent writers creates the object. OSS has no conditional overwrite, so
+// OSSClient is not a ConditionalWriter.
+func (o *OSSClient) CreateObject(dst string, content []byte) error {
+	_, key, err := o.parseUri(dst)
+	if err != nil {
 		return err
 	}
+	if key == "" {
+		return errors.New("write failed because the OSS path is invalid and the object key is missing")
+	}
+	err = o.bucket.PutObject(key, bytes.NewReader(content), oss.ForbidOverWrite(true), o.ctxOption())
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w. Path: %s", errPreconditionFailed, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("write failed while uploading OSS object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (o *OSSClient) List(prefix string) ([]ObjectInfo, error) {
+	_, key, err := o.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
 
+	objects := make([]ObjectInfo, 0)
 	marker := ""
-	found := false
 	for {
 		result, err := o.bucket.ListObjectsV2(
-			oss.Prefix(prefix),
+			oss.Prefix(key),
 			oss.ContinuationToken(marker),
+			o.ctxOption(),
 		)
 		if err != nil {
-			return fmt.Errorf("pull failed while listing OSS objects under prefix %s: %w", prefix, err)
+			return nil, fmt.Errorf("list failed while listing OSS objects under prefix %s: %w", key, err)
 		}
 		for _, obj := range result.Objects {
-			found = true
-			rel := strings.TrimPrefix(obj.Key, prefix)
-			localFile := filepath.Join(dst, filepath.FromSlash(rel))
-			if err := os.MkdirAll(filepath.Dir(localFile), 0o755); err != nil {
-				return err
-			}
-			if err := o.bucket.GetObjectToFile(obj.Key, localFile); err != nil {
-				return fmt.Errorf("pull failed while downloading OSS object %s: %w", obj.Key, err)
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
 			}
+			objects = append(objects, ObjectInfo{
+				Path:    strings.TrimPrefix(obj.Key, key),
+				Size:    obj.Size,
+				ETag:    strings.Trim(obj.ETag, "\""),
+				ModTime: obj.LastModified,
+			})
 		}
 		if !result.IsTruncated {
 			break
 		}
 		marker = result.NextContinuationToken
 	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
 
-	if !found {
-		return fmt.Errorf("%w. Source path: %s", errOSSObjectOrPrefixNotFound, src)
+func (o *OSSClient) Delete(path string) error {
+	_, key, err := o.parseUri(path)
+	if err != nil {
+		return err
 	}
-	return nil
+	if key == "" {
+		return errors.New("delete failed because the OSS path is invalid and the object key is missing")
+	}
+	return o.deletePrefixContents(key)
 }
 
-func (o *OSSClient) Push(src string, dst string) error {
-	_, key, err := o.parseUri(dst)
+func (o *OSSClient) Stat(path string) (ObjectInfo, error) {
+	_, key, err := o.parseUri(path)
 	if err != nil {
-		return err
+		return ObjectInfo{}, err
 	}
 	if key == "" {
-		return errors.New("push failed because the OSS path is invalid and the object key is missing")
+		return ObjectInfo{}, errors.New("stat failed because the OSS path is invalid and the object key is missing")
 	}
 
-	stat, err := os.Stat(src)
+	exists, err := o.bucket.IsObjectExist(key, o.ctxOption())
 	if err != nil {
-		return fmt.Errorf("push failed because the local source path is unavailable: %s", src)
+		return ObjectInfo{}, fmt.Errorf("stat failed while checking whether the OSS object exists: %w", err)
 	}
-	if stat.IsDir() {
-		if err := o.deletePrefixContents(key); err != nil {
-			return err
-		}
-		return filepath.Walk(src, func(path string, fileInfo os.FileInfo, walkErr error) error {
-			if walkErr != nil {
-				return walkErr
-			}
-			if fileInfo.IsDir() {
-				return nil
-			}
-			rel, err := filepath.Rel(src, path)
-			if err != nil {
-				return err
-			}
-			if rel == "." {
-				return nil
-			}
-			objectKey := joinOSSKey(key, filepath.ToSlash(rel))
-			if err := o.bucket.PutObjectFromFile(objectKey, path); err != nil {
-				return fmt.Errorf("push failed while uploading OSS object %s: %w", objectKey, err)
-			}
-			return nil
-		})
-	} else {
-		if err := o.bucket.PutObjectFromFile(key, src); err != nil {
-			return fmt.Errorf("push failed while uploading OSS object %s: %w", key, err)
-		}
-		return nil
+	if !exists {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
 	}
+	header, err := o.bucket.GetObjectDetailedMeta(key, o.ctxOption())
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading OSS object metadata %s: %w", key, err)
+	}
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	return ObjectInfo{
+		Path:    key,
+		Size:    size,
+		ETag:    strings.Trim(header.Get("ETag"), "\""),
+		ModTime: modTime,
+	}, nil
 }
 
-func (o *OSSClient) ReadObject(src string) ([]byte, error) {
+func (o *OSSClient) downloadFile(ctx context.Context, src string, dst string) error {
 	_, key, err := o.parseUri(src)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if key == "" {
-		return nil, errors.New("read failed because the OSS path is invalid and the object key is missing")
+	if err := o.bucket.GetObjectToFile(key, dst, oss.WithContext(ctx)); err != nil {
+		return fmt.Errorf("pull failed while downloading OSS object %s: %w", key, err)
 	}
+	return nil
+}
 
-	exists, err := o.bucket.IsObjectExist(key)
+func (o *OSSClient) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	_, key, err := o.parseUri(src)
 	if err != nil {
-		return nil, fmt.Errorf("read failed while checking whether the OSS object exists: %w", err)
+		return err
 	}
-	if !exists {
-		return nil, os.ErrNotExist
+	options := []oss.Option{oss.WithContext(ctx)}
+	if offset > 0 {
+		options = append(options, oss.NormalizedRange(fmt.Sprintf("%d-", offset)))
+	}
+	reader, err := o.bucket.GetObject(key, options...)
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
 	}
-
-	reader, err := o.bucket.GetObject(key)
 	if err != nil {
-		return nil, fmt.Errorf("read failed while opening OSS object %s: %w", key, err)
+		return fmt.Errorf("pull failed while downloading OSS object %s: %w", key, err)
 	}
 	defer reader.Close()
-
-	content, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("read failed while reading content from OSS object %s: %w", key, err)
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("pull failed while downloading OSS object %s: %w", key, err)
 	}
-	return content, nil
+	return nil
 }
 
-func (o *OSSClient) WriteObject(dest string, content []byte) error {
-	_, key, err := o.parseUri(dest)
+// uploadFile uses the SDK's checkpointed multipart upload for large files, so
+// running an interrupted push again continues with the remaining parts.
+func (o *OSSClient) uploadFile(ctx context.Context, src string, dst string) error {
+	_, key, err := o.parseUri(dst)
 	if err != nil {
 		return err
 	}
 	if key == "" {
-		return errors.New("write failed because the OSS path is invalid and the object key is missing")
+		return errors.New("push failed because the OSS path is invalid and the object key is missing")
 	}
-	if err := o.bucket.PutObject(key, bytes.NewReader(content)); err != nil {
-		return fmt.Errorf("write failed while uploading OSS object %s: %w", key, err)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() > multipartThreshold {
+		options := []oss.Option{oss.WithContext(ctx)}
+		if path := uploadCheckpointPath(src, dst); path != "" {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			options = append(options, oss.Checkpoint(true, path))
+		}
+		if err := o.bucket.UploadFile(key, src, multipartPartSize, options...); err != nil {
+			return fmt.Errorf("push failed while uploading OSS object %s in parts: %w", key, err)
+		}
+		return nil
+	}
+	if err := o.bucket.PutObjectFromFile(key, src, oss.WithContext(ctx)); err != nil {
+		return fmt.Errorf("push failed while uploading OSS object %s: %w", key, err)
 	}
 	return nil
 }
@@ -217,16 +299,17 @@ func (o *OSSClient) deletePrefixContents(base string) error {
 		result, err := o.bucket.ListObjectsV2(
 			oss.Prefix(base),
 			oss.ContinuationToken(marker),
+			o.ctxOption(),
 		)
 		if err != nil {
-			return fmt.Errorf("push failed while listing existing OSS objects under prefix %s before overwrite: %w", base, err)
+			return fmt.Errorf("delete failed while listing existing OSS objects under prefix %s: %w", base, err)
 		}
 		for _, obj := range result.Objects {
 			if obj.Key != base && !strings.HasPrefix(obj.Key, base+"/") {
 				continue
 			}
-			if err := o.bucket.DeleteObject(obj.Key); err != nil {
-				return fmt.Errorf("push failed while deleting stale OSS object %s: %w", obj.Key, err)
+			if err := o.bucket.DeleteObject(obj.Key, o.ctxOption()); err != nil {
+				return fmt.Errorf("delete failed while deleting stale OSS object %s: %w", obj.Key, err)
 			}
 		}
 		if !result.IsTruncated {
@@ -260,6 +343,10 @@ func (o *OSSClient) parseUri(raw string) (string, string, error) {
 	return cfgBucket, key, nil
 }
 
+func (o *OSSClient) ctxOption() oss.Option {
+	return oss.WithContext(o.opts.ctx())
+}
+
 func normalizeEndpoint(endpoint string) string {
 	if strings.Contains(endpoint, "://") {
 		return endpoint
@@ -269,15 +356,3 @@ func normalizeEndpoint(endpoint string) string {
 	}
 	return "https://" + endpoint
 }
-
-func joinOSSKey(base, rel string) string {
-	base = strings.TrimSuffix(base, "/")
-	rel = strings.TrimPrefix(rel, "/")
-	if rel == "" {
-		return base
-	}
-	if base == "" {
-		return rel
-	}
-	return base + "/" + rel
-}
@@ -0,0 +1,252 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProgressReporter receives transfer progress from backends. Totals can grow
+// while a command runs, for example when blobs are uploaded one at a time.
+type ProgressReporter interface {
+	AddTotal(files int, bytes int64)
+	AddBytes(n int64)
+	FileDone()
+	FileSkipped()
+	FileDeleted()
+}
+
+type noProgress struct{}
+
+func (noProgress) AddTotal(files int, bytes int64) {}
+func (noProgress) AddBytes(n int64)                {}
+func (noProgress) FileDone()                       {}
+func (noProgress) FileSkipped()                    {}
+func (noProgress) FileDeleted()                    {}
+
+// progressStats is a snapshot of the counters of a Progress.
+type progressStats struct {
+	files      int
+	done       int
+	skipped    int
+	deleted    int
+	totalBytes int64
+	doneBytes  int64
+	elapsed    time.Duration
+}
+
+func (s progressStats) isEmpty() bool {
+	return s.files == 0 && s.skipped == 0 && s.deleted == 0
+}
+
+func (s progressStats) line() string {
+	line := fmt.Sprintf("%d/%d files, %s/%s", s.done, s.files, formatBytes(s.doneBytes), formatBytes(s.totalBytes))
+	if s.elapsed <= 0 || s.doneBytes <= 0 {
+		return line
+	}
+	rate := float64(s.doneBytes) / s.elapsed.Seconds()
+	line += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+	if remaining := s.totalBytes - s.doneBytes; remaining > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+func (s progressStats) summary() string {
+	return fmt.Sprintf("transfer summary: %d files transferred (%s), %d skipped, %d deleted in %s",
+		s.done, formatBytes(s.doneBytes), s.skipped, s.deleted, s.elapsed.Round(100*time.Millisecond))
+}
+
+// progressRenderer draws progress lines. The TTY renderer keeps rewriting a
+// single line, the plain renderer appends a line whenever something changed.
+type progressRenderer interface {
+	interval() time.Duration
+	render(stats progressStats)
+	clear()
+}
+
+type ttyRenderer struct {
+	out   io.Writer
+	drawn bool
+}
+
+func (r *ttyRenderer) interval() time.Duration {
+	return 200 * time.Millisecond
+}
+
+func (r *ttyRenderer) render(stats progressStats) {
+	fmt.Fprintf(r.out, "\r\033[K%s", stats.line())
+	r.drawn = true
+}
+
+func (r *ttyRenderer) clear() {
+	if r.drawn {
+		fmt.Fprint(r.out, "\r\033[K")
+		r.drawn = false
+	}
+}
+
+type plainRenderer struct {
+	out  io.Writer
+	last progressStats
+}
+
+func (r *plainRenderer) interval() time.Duration {
+	return 5 * time.Second
+}
+
+func (r *plainRenderer) render(stats progressStats) {
+	if stats.done == r.last.done && stats.doneBytes == r.last.doneBytes && stats.files == r.last.files {
+		return
+	}
+	r.last = stats
+	fmt.Fprintln(r.out, stats.line())
+}
+
+func (r *plainRenderer) clear() {
+	r.last = progressStats{}
+}
+
+// Progress counts transferred files and bytes and periodically renders them.
+// Finish prints a summary of everything counted since the previous Finish,
+// and Close stops rendering.
+type Progress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	renderer progressRenderer
+	start    time.Time
+	stats    progressStats
+	stop     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func NewProgress(out *os.File) *Progress {
+	var renderer progressRenderer = &plainRenderer{out: out}
+	if info, err := out.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		renderer = &ttyRenderer{out: out}
+	}
+	p := &Progress{
+		out:      out,
+		renderer: renderer,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+func (p *Progress) AddTotal(files int, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.files += files
+	if bytes > 0 {
+		p.stats.totalBytes += bytes
+	}
+}
+
+func (p *Progress) AddBytes(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.doneBytes += n
+}
+
+func (p *Progress) FileDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.done++
+}
+
+func (p *Progress) FileSkipped() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.skipped++
+}
+
+func (p *Progress) FileDeleted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.deleted++
+}
+
+// Finish clears the progress line and prints the summary, so commands call it
+// before printing their own result. Counting starts over afterwards.
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.renderer.clear()
+	stats := p.snapshot()
+	if !stats.isEmpty() {
+		fmt.Fprintln(p.out, stats.summary())
+	}
+	p.stats = progressStats{}
+	p.start = time.Now()
+}
+
+func (p *Progress) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.stopped
+		p.Finish()
+	})
+}
+
+func (p *Progress) loop() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.renderer.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if stats := p.snapshot(); stats.files > 0 {
+				p.renderer.render(stats)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Progress) snapshot() progressStats {
+	stats := p.stats
+	stats.elapsed = time.Since(p.start)
+	return stats
+}
+
+// finishProgress prints the summary of a reporter that supports it.
+func finishProgress(reporter ProgressReporter) {
+	if finisher, ok := reporter.(interface{ Finish() }); ok {
+		finisher.Finish()
+	}
+}
+
+// progressWriter reports every write to a reporter.
+type progressWriter struct {
+	w        io.Writer
+	reporter ProgressReporter
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.reporter.AddBytes(int64(n))
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
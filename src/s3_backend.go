@@ -0,0 +1,624 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3BackendProvider = "s3"
+	s3DefaultRegion   = "us-east-1"
+)
+
+type S3Backend struct {
+	cfg      OSSConfig
+	bucket   string
+	region   string
+	endpoint *url.URL
+	client   *http.Client
+	opts     BackendOptions
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		ETag         string    `xml:"ETag"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func init() {
+	RegisterBackend("s3", s3BackendProvider, func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewS3Backend(cfg, opts)
+	})
+}
+
+func NewS3Backend(cfg OSSConfig, opts BackendOptions) (*S3Backend, error) {
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("failed to initialize S3 client because access key and secret key are required")
+	}
+	if cfg.Bucket == "" || cfg.Bucket == "/" {
+		return nil, errors.New("failed to initialize S3 client because bucket is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	rawEndpoint := cfg.Endpoint
+	if rawEndpoint == "" {
+		rawEndpoint = fmt.Sprintf("s3.%s.amazonaws.com", region)
+	}
+	if !strings.Contains(rawEndpoint, "://") {
+		rawEndpoint = "https://" + rawEndpoint
+	}
+	endpoint, err := url.Parse(rawEndpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("failed to initialize S3 client because the endpoint is invalid: %s", cfg.Endpoint)
+	}
+
+	return &S3Backend{
+		cfg:      cfg,
+		bucket:   strings.Trim(cfg.Bucket, "/"),
+		region:   region,
+		endpoint: endpoint,
+		client:   http.DefaultClient,
+		opts:     opts,
+	}, nil
+}
+
+func (s *S3Backend) Pull(src string, dst string) error {
+	return pullTree(s, s.opts, src, dst)
+}
+
+func (s *S3Backend) Push(src string, dst string) error {
+	return pushTree(s, s.opts, src, dst)
+}
+
+func (s *S3Backend) ReadObject(src string) ([]byte, error) {
+	key, err := s.parseUri(src)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, errors.New("read failed because the S3 path is invalid and the object key is missing")
+	}
+
+	resp, err := s.do(s.opts.ctx(), http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while opening S3 object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := s.checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("read failed while opening S3 object %s: %w", key, err)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading content from S3 object %s: %w", key, err)
+	}
+	return content, nil
+}
+
+func (s *S3Backend) WriteObject(dst string, content []byte) error {
+	key, err := s.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("write failed because the S3 path is invalid and the object key is missing")
+	}
+	if err := s.put(s.opts.ctx(), key, content, nil); err != nil {
+		return fmt.Errorf("write failed while uploading S3 object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	key, err := s.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+
+	objects := make([]ObjectInfo, 0)
+	err = s.listKeys(key, func(obj ObjectInfo) {
+		if strings.HasSuffix(obj.Path, "/") {
+			return
+		}
+		obj.Path = strings.TrimPrefix(obj.Path, key)
+		objects = append(objects, obj)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list failed while listing S3 objects under prefix %s: %w", key, err)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (s *S3Backend) Delete(path string) error {
+	base, err := s.parseUri(path)
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		return errors.New("delete failed because the S3 path is invalid and the object key is missing")
+	}
+
+	keys := make([]string, 0)
+	err = s.listKeys(base, func(obj ObjectInfo) {
+		if obj.Path == base || strings.HasPrefix(obj.Path, base+"/") {
+			keys = append(keys, obj.Path)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("delete failed while listing existing S3 objects under prefix %s: %w", base, err)
+	}
+	for _, key := range keys {
+		resp, err := s.do(s.opts.ctx(), http.MethodDelete, key, nil, nil, nil)
+		if err == nil {
+			err = s.checkResponse(resp)
+			resp.Body.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("delete failed while deleting stale S3 object %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *S3Backend) Stat(path string) (ObjectInfo, error) {
+	key, err := s.parseUri(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if key == "" {
+		return ObjectInfo{}, errors.New("stat failed because the S3 path is invalid and the object key is missing")
+	}
+
+	resp, err := s.do(s.opts.ctx(), http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading S3 object metadata %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	if err := s.checkResponse(resp); err != nil {
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading S3 object metadata %s: %w", key, err)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{
+		Path:    key,
+		Size:    resp.ContentLength,
+		ETag:    strings.Trim(resp.Header.Get("ETag"), "\""),
+		ModTime: modTime,
+	}, nil
+}
+
+func (s *S3Backend) downloadFile(ctx context.Context, src string, dst string) error {
+	key, err := s.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading S3 object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := s.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading S3 object %s: %w", key, err)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("pull failed while downloading S3 object %s: %w", key, err)
+	}
+	return file.Close()
+}
+
+func (s *S3Backend) uploadFile(ctx context.Context, src string, dst string) error {
+	key, err := s.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("push failed because the S3 path is invalid and the object key is missing")
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Size() > multipartThreshold {
+		if err := s.uploadMultipart(ctx, src, dst, key, info); err != nil {
+			return fmt.Errorf("push failed while uploading S3 object %s in parts: %w", key, err)
+		}
+		return nil
+	}
+	content, err := os.ReadFile(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	if err := s.put(ctx, key, content, nil); err != nil {
+		return fmt.Errorf("push failed while uploading S3 object %s: %w", key, err)
+	}
+	return nil
+}
+
+// uploadMultipart uploads a large file part by part. Finished parts are
+// recorded in a checkpoint, so an interrupted upload resumes with the same
+// upload id. An upload id the server no longer knows starts over once.
+func (s *S3Backend) uploadMultipart(ctx context.Context, src string, dst string, key string, info os.FileInfo) error {
+	cp := loadUploadCheckpoint(src, dst, info)
+	for attempt := 1; ; attempt++ {
+		err := s.uploadParts(ctx, key, &cp)
+		if err == nil || !errors.Is(err, errRemoteNotFound) || attempt > 1 {
+			if err == nil {
+				cp.remove()
+			}
+			return err
+		}
+		cp.remove()
+		cp.UploadID, cp.Parts = "", map[int]string{}
+	}
+}
+
+func (s *S3Backend) uploadParts(ctx context.Context, key string, cp *uploadCheckpoint) error {
+	if cp.UploadID == "" {
+		resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := s.checkResponse(resp); err != nil {
+			return err
+		}
+		var result s3InitiateResult
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to parse S3 multipart upload response: %w", err)
+		}
+		cp.UploadID = result.UploadID
+		if err := cp.save(); err != nil {
+			return err
+		}
+	}
+
+	for number := 1; number <= cp.partCount(); number++ {
+		if _, ok := cp.Parts[number]; ok {
+			continue
+		}
+		content, err := cp.readPart(number)
+		if err != nil {
+			return err
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {cp.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, content, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w. Upload id: %s", errRemoteNotFound, cp.UploadID)
+		}
+		if err := s.checkResponse(resp); err != nil {
+			return err
+		}
+		cp.Parts[number] = resp.Header.Get("ETag")
+		if err := cp.save(); err != nil {
+			return err
+		}
+	}
+
+	complete := s3CompleteUpload{}
+	for number := 1; number <= cp.partCount(); number++ {
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: number, ETag: cp.Parts[number]})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {cp.UploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Upload id: %s", errRemoteNotFound, cp.UploadID)
+	}
+	// Completion can fail with status 200 and an error document.
+	content, _ := io.ReadAll(resp.Body)
+	var s3Err s3Error
+	if resp.StatusCode >= 300 || (xml.Unmarshal(content, &s3Err) == nil && s3Err.Code != "") {
+		return fmt.Errorf("S3 request failed with status %d. Code: %s. Message: %s", resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return nil
+}
+
+func (s *S3Backend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	key, err := s.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, rangeHeader(offset))
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading S3 object %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := s.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading S3 object %s: %w", key, err)
+	}
+	return copyRangeResponse(resp, offset, w)
+}
+
+func (s *S3Backend) WriteObjectIfMatch(dst string, content []byte, etag string) error {
+	key, err := s.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("write failed because the S3 path is invalid and the object key is missing")
+	}
+	headers := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		headers = map[string]string{"If-Match": `"` + etag + `"`}
+	}
+	if err := s.put(s.opts.ctx(), key, content, headers); err != nil {
+		return fmt.Errorf("write failed while uploading S3 object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *S3Backend) put(ctx context.Context, key string, content []byte, headers map[string]string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, content, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		return errPreconditionFailed
+	}
+	return s.checkResponse(resp)
+}
+
+func (s *S3Backend) listKeys(prefix string, visit func(obj ObjectInfo)) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(s.opts.ctx(), http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := s.checkResponse(resp); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse S3 list response: %w", err)
+		}
+		for _, obj := range result.Contents {
+			visit(ObjectInfo{
+				Path:    obj.Key,
+				Size:    obj.Size,
+				ETag:    strings.Trim(obj.ETag, "\""),
+				ModTime: obj.LastModified,
+			})
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Backend) do(ctx context.Context, method string, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = "/" + key
+	if s.cfg.PathStyle {
+		target.Path = "/" + s.bucket + target.Path
+	} else {
+		target.Host = s.bucket + "." + s.endpoint.Host
+	}
+	target.RawPath = s3EscapePath(target.Path)
+	target.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s.sign(req, target.RawPath, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign applies AWS Signature Version 4 to the request.
+func (s *S3Backend) sign(req *http.Request, canonicalPath string, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headerNames := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func (s *S3Backend) checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	content, _ := io.ReadAll(resp.Body)
+	var s3Err s3Error
+	if xml.Unmarshal(content, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("S3 request failed with status %d. Code: %s. Message: %s", resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("S3 request failed with status %d", resp.StatusCode)
+}
+
+func (s *S3Backend) parseUri(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "s3://") {
+		return "", fmt.Errorf("invalid S3 path because the scheme is not s3://. Path: %s", raw)
+	}
+	withoutScheme := strings.TrimPrefix(raw, "s3://")
+	parts := strings.SplitN(withoutScheme, "/", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		return "", fmt.Errorf("invalid S3 path because the bucket segment is missing. Path: %s", raw)
+	}
+	if parts[0] != s.bucket {
+		return "", fmt.Errorf("invalid S3 path because the bucket does not match the configured bucket. Path bucket: %s. Config bucket: %s", parts[0], s.bucket)
+	}
+	if len(parts) == 1 {
+		return "", nil
+	}
+	return strings.TrimPrefix(parts[1], "/"), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3Escape(value string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(key string) string {
+	return s3Escape(key, true)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
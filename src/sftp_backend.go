@@ -0,0 +1,385 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sftpBackendProvider = "sftp"
+	sftpDialTimeout     = 30 * time.Second
+)
+
+// sftpKeyFiles are tried in order after the keys held by ssh-agent.
+var sftpKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sftpClients keeps one connection per user@host:port for the lifetime of the
+// process, since a backend is opened for every remote path. A connection is
+// dropped once it closes and the next call dials again.
+var (
+	sftpClientsMu sync.Mutex
+	sftpClients   = map[string]*sftp.Client{}
+)
+
+type SFTPBackend struct {
+	cfg  OSSConfig
+	opts BackendOptions
+}
+
+type sftpHost struct {
+	user string
+	addr string
+}
+
+func init() {
+	factory := func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewSFTPBackend(cfg, opts)
+	}
+	RegisterBackend("sftp", sftpBackendProvider, factory)
+	RegisterBackend("ssh", "", factory)
+}
+
+func NewSFTPBackend(cfg OSSConfig, opts BackendOptions) (*SFTPBackend, error) {
+	return &SFTPBackend{cfg: cfg, opts: opts}, nil
+}
+
+func (s *SFTPBackend) Pull(src string, dst string) error {
+	return pullTree(s, s.opts, src, dst)
+}
+
+func (s *SFTPBackend) Push(src string, dst string) error {
+	return pushTree(s, s.opts, src, dst)
+}
+
+func (s *SFTPBackend) ReadObject(src string) ([]byte, error) {
+	client, remotePath, err := s.open(src)
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(remotePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+		}
+		return nil, fmt.Errorf("read failed while opening remote file %s: %w", src, err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading remote file %s: %w", src, err)
+	}
+	return content, nil
+}
+
+func (s *SFTPBackend) WriteObject(dst string, content []byte) error {
+	if err := s.write(s.opts.ctx(), dst, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("write failed while uploading remote file %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (s *SFTPBackend) List(prefix string) ([]ObjectInfo, error) {
+	client, remotePath, err := s.open(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]ObjectInfo, 0)
+	walker := client.Walk(remotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, os.ErrNotExist) && walker.Path() == remotePath {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("list failed while listing remote directory %s: %w", prefix, err)
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+		obj := s.objectInfo(walker.Stat())
+		obj.Path = strings.TrimPrefix(walker.Path(), strings.TrimSuffix(remotePath, "/")+"/")
+		objects = append(objects, obj)
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (s *SFTPBackend) Delete(path string) error {
+	client, remotePath, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	if err := client.RemoveAll(remotePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete failed while removing remote path %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *SFTPBackend) Stat(path string) (ObjectInfo, error) {
+	client, remotePath, err := s.open(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := client.Stat(remotePath)
+	if err == nil && !info.Mode().IsRegular() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+		}
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading remote file %s: %w", path, err)
+	}
+	obj := s.objectInfo(info)
+	obj.Path = remotePath
+	return obj, nil
+}
+
+func (s *SFTPBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	return s.download(ctx, src, 0, func() (io.Writer, func() error, error) {
+		file, err := os.Create(dst)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, file.Close, nil
+	})
+}
+
+func (s *SFTPBackend) ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error {
+	return s.download(ctx, src, offset, func() (io.Writer, func() error, error) {
+		return w, func() error { return nil }, nil
+	})
+}
+
+// download copies a remote file from offset into the writer create returns,
+// which is only called once the remote file is known to exist.
+func (s *SFTPBackend) download(ctx context.Context, src string, offset int64, create func() (io.Writer, func() error, error)) error {
+	client, remotePath, err := s.open(src)
+	if err != nil {
+		return err
+	}
+	file, err := client.Open(remotePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+		}
+		return fmt.Errorf("pull failed while downloading remote file %s: %w", src, err)
+	}
+	defer file.Close()
+	stop := context.AfterFunc(ctx, func() { _ = file.Close() })
+	defer stop()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("pull failed while downloading remote file %s: %w", src, err)
+	}
+
+	w, closeWriter, err := create()
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteTo(w); err != nil {
+		_ = closeWriter()
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("pull failed while downloading remote file %s: %w", src, err)
+	}
+	return closeWriter()
+}
+
+func (s *SFTPBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := s.write(ctx, dst, file); err != nil {
+		return fmt.Errorf("push failed while uploading remote file %s: %w", dst, err)
+	}
+	return nil
+}
+
+// write uploads to a temporary file next to dst and renames it over dst, so
+// readers never see a partial file.
+func (s *SFTPBackend) write(ctx context.Context, dst string, content io.Reader) error {
+	client, remotePath, err := s.open(dst)
+	if err != nil {
+		return err
+	}
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+	tmp := remotePath + ".tmp"
+	file, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = file.Close() })
+	_, err = file.ReadFrom(content)
+	stop()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	if err == nil {
+		err = client.PosixRename(tmp, remotePath)
+	}
+	if err != nil {
+		_ = client.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// objectInfo derives the ETag from the modification time and size, like the
+// file backend, because SFTP has no way to checksum a file on the server.
+func (s *SFTPBackend) objectInfo(info os.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Size:    info.Size(),
+		ETag:    fmt.Sprintf("%x-%x", info.ModTime().Unix(), info.Size()),
+		ModTime: info.ModTime(),
+	}
+}
+
+// open returns the client for the host of raw, connecting on first use, and
+// the remote path.
+func (s *SFTPBackend) open(raw string) (*sftp.Client, string, error) {
+	host, remotePath, err := s.parseUri(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	key := host.user + "@" + host.addr
+	sftpClientsMu.Lock()
+	defer sftpClientsMu.Unlock()
+	if client, ok := sftpClients[key]; ok {
+		return client, remotePath, nil
+	}
+	client, err := s.dial(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to sftp host %s: %w", host.addr, err)
+	}
+	sftpClients[key] = client
+	go func() {
+		_ = client.Wait()
+		sftpClientsMu.Lock()
+		if sftpClients[key] == client {
+			delete(sftpClients, key)
+		}
+		sftpClientsMu.Unlock()
+	}()
+	return client, remotePath, nil
+}
+
+// dial authenticates with the keys held by ssh-agent and the default keys in
+// ~/.ssh. The host key must already be in ~/.ssh/known_hosts.
+func (s *SFTPBackend) dial(host sftpHost) (*sftp.Client, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("the host key cannot be verified because known_hosts could not be read: %w", err)
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			defer conn.Close()
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	for _, name := range sftpKeyFiles {
+		content, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// Keys with a passphrase are only usable through ssh-agent.
+		signer, err := ssh.ParsePrivateKey(content)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no private key was found in ssh-agent or ~/.ssh")
+	}
+
+	conn, err := ssh.Dial("tcp", host.addr, &ssh.ClientConfig{
+		User:            host.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return nil, fmt.Errorf("the host key is not in ~/.ssh/known_hosts. Please connect once with ssh to add it: %w", err)
+			}
+			return nil, fmt.Errorf("the host key does not match ~/.ssh/known_hosts: %w", err)
+		}
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *SFTPBackend) parseUri(raw string) (sftpHost, string, error) {
+	scheme := uriScheme(raw)
+	if scheme != "sftp" && scheme != "ssh" {
+		return sftpHost{}, "", fmt.Errorf("invalid sftp path because the scheme is not sftp:// or ssh://. Path: %s", raw)
+	}
+	withoutScheme := raw[len(scheme)+len("://"):]
+	parts := strings.SplitN(withoutScheme, "/", 2)
+	if parts[0] == "" {
+		return sftpHost{}, "", fmt.Errorf("invalid sftp path because the host segment is missing. Path: %s", raw)
+	}
+	if len(parts) == 1 || strings.Trim(parts[1], "/") == "" {
+		return sftpHost{}, "", fmt.Errorf("invalid sftp path because the remote path is missing. Path: %s", raw)
+	}
+
+	host := sftpHost{user: s.cfg.Username, addr: parts[0]}
+	if idx := strings.LastIndex(host.addr, "@"); idx >= 0 {
+		host.user, host.addr = host.addr[:idx], host.addr[idx+1:]
+	}
+	if host.user == "" {
+		current, err := user.Current()
+		if err != nil {
+			return sftpHost{}, "", fmt.Errorf("invalid sftp path because the user segment is missing. Path: %s", raw)
+		}
+		host.user = current.Username
+	}
+	if _, _, err := net.SplitHostPort(host.addr); err != nil {
+		host.addr = net.JoinHostPort(host.addr, "22")
+	}
+	// sftp://host/~/dir is relative to the login directory.
+	remotePath := "/" + strings.TrimSuffix(parts[1], "/")
+	if strings.HasPrefix(remotePath, "/~/") {
+		remotePath = strings.TrimPrefix(remotePath, "/~/")
+	}
+	return host, remotePath, nil
+}
@@ -0,0 +1,195 @@
+package src
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSFTPBackend(t *testing.T) {
+	server := newSFTPTestServer(t)
+	server.trust(t)
+	s, err := NewSFTPBackend(OSSConfig{Name: "sftp"}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "sftp://me@" + server.addr + server.root
+
+	testBackendContract(t, s, root)
+	if _, err := s.Stat(root + "/donk"); !errors.Is(err, errRemoteNotFound) {
+		t.Fatalf("Stat() of a directory = %v, want %v", err, errRemoteNotFound)
+	}
+
+	// ~/ is relative to the login directory, which is the server root here.
+	if err := s.WriteObject("sftp://me@"+server.addr+"/~/home.txt", []byte("home")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(server.root, "home.txt")); err != nil || string(content) != "home" {
+		t.Fatalf("~/home.txt = %q, %v, want home in the login directory", content, err)
+	}
+
+	if logins := server.logins.Load(); logins != 1 {
+		t.Fatalf("logged in %d times, want the connection to be reused", logins)
+	}
+}
+
+func TestSFTPBackendHostKey(t *testing.T) {
+	server := newSFTPTestServer(t)
+	s, err := NewSFTPBackend(OSSConfig{Name: "sftp", Username: "me"}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "sftp://" + server.addr + server.root + "/file"
+	knownHosts := filepath.Join(server.home, ".ssh", "known_hosts")
+
+	if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadObject(src); err == nil || !strings.Contains(err.Error(), "is not in ~/.ssh/known_hosts") {
+		t.Fatalf("ReadObject() from an unknown host = %v, want a known_hosts error", err)
+	}
+
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, otherSigner.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadObject(src); err == nil || !strings.Contains(err.Error(), "does not match ~/.ssh/known_hosts") {
+		t.Fatalf("ReadObject() from a host with another key = %v, want a known_hosts mismatch", err)
+	}
+	if logins := server.logins.Load(); logins != 0 {
+		t.Fatalf("logged in %d times to an untrusted host", logins)
+	}
+}
+
+// sftpTestServer is an SSH server on localhost that only offers the sftp
+// subsystem, rooted at a temporary directory. It accepts the key it writes to
+// ~/.ssh/id_ed25519 of a temporary home.
+type sftpTestServer struct {
+	addr    string
+	root    string
+	home    string
+	hostKey ssh.Signer
+	logins  atomic.Int32
+}
+
+func newSFTPTestServer(t *testing.T) *sftpTestServer {
+	t.Helper()
+	server := &sftpTestServer{root: t.TempDir(), home: t.TempDir()}
+	t.Setenv("HOME", server.home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.hostKey, err = ssh.NewSignerFromKey(hostKey); err != nil {
+		t.Fatal(err)
+	}
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(server.home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(server.home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "me" || !bytes.Equal(key.Marshal(), userSigner.PublicKey().Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			server.logins.Add(1)
+			return nil, nil
+		},
+	}
+	config.AddHostKey(server.hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	server.addr = listener.Addr().String()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+// trust adds the host key of the server to known_hosts.
+func (s *sftpTestServer) trust(t *testing.T) {
+	t.Helper()
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey.PublicKey())
+	if err := os.WriteFile(filepath.Join(s.home, ".ssh", "known_hosts"), []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *sftpTestServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(s.root))
+					if err == nil {
+						_ = server.Serve()
+					}
+					_ = channel.Close()
+				}
+			}
+		}()
+	}
+}
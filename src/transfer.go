@@ -0,0 +1,303 @@
+package src
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Files above multipartThreshold are uploaded in parts of
+	// multipartPartSize, so an interrupted upload can continue from the last
+	// finished part.
+	multipartThreshold = 64 << 20
+	multipartPartSize  = 16 << 20
+)
+
+// RangeReader is implemented by backends that can download an object starting
+// at an offset, which lets interrupted downloads resume.
+type RangeReader interface {
+	ReadRange(ctx context.Context, src string, offset int64, w io.Writer) error
+}
+
+var errRangeUnsupported = errors.New("the server ignored the range request")
+
+// downloadState is kept next to a staging path and records which remote
+// objects a partial download belongs to.
+type downloadState struct {
+	Source  string            `json:"source"`
+	Objects map[string]string `json:"objects"`
+}
+
+// pullResumable downloads src into the staging path like Backend.Pull, but
+// keeps whatever an interrupted run already downloaded as long as the remote
+// objects did not change since, and only fetches what is still missing.
+func pullResumable(context Context, src string, staging string) error {
+	backend, err := context.openBackend(src)
+	if err != nil {
+		return err
+	}
+	objects, single, err := listDownload(backend, src)
+	if err != nil {
+		return err
+	}
+
+	state := downloadState{Source: src, Objects: map[string]string{}}
+	for _, obj := range objects {
+		state.Objects[obj.Path] = fmt.Sprintf("%d:%s:%d", obj.Size, obj.ETag, obj.ModTime.UnixNano())
+	}
+	statePath := staging + ".json"
+	var previous downloadState
+	content, err := os.ReadFile(statePath)
+	if err != nil || json.Unmarshal(content, &previous) != nil || previous.Source != src || !maps.Equal(previous.Objects, state.Objects) {
+		if err := removeStaging(staging); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(staging), 0o755); err != nil {
+			return err
+		}
+		content, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(statePath, content, 0o644); err != nil {
+			return err
+		}
+	}
+
+	opts := context.backendOptions()
+	if single {
+		return downloadResumable(opts.ctx(), backend, opts, src, staging, objects[0].Size)
+	}
+	return downloadAll(backend, opts, src, objects, staging)
+}
+
+func downloadAll(backend Backend, opts BackendOptions, src string, objects []ObjectInfo, dst string) error {
+	return runParallel(opts.ctx(), transferJobs(backend, opts), len(objects), func(ctx context.Context, idx int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj := objects[idx]
+		return downloadResumable(ctx, backend, opts, joinRemotePath(src, obj.Path), filepath.Join(dst, filepath.FromSlash(obj.Path)), obj.Size)
+	})
+}
+
+// listDownload returns the objects to download for src, which is either a
+// single object or a prefix.
+func listDownload(backend Backend, src string) ([]ObjectInfo, bool, error) {
+	if info, err := backend.Stat(src); err == nil {
+		info.Path = ""
+		return []ObjectInfo{info}, true, nil
+	} else if !errors.Is(err, errRemoteNotFound) {
+		return nil, false, fmt.Errorf("pull failed while checking whether the remote object exists: %w", err)
+	}
+	listed, err := backend.List(src)
+	if err != nil {
+		return nil, false, err
+	}
+	objects := make([]ObjectInfo, 0, len(listed))
+	for _, obj := range listed {
+		if !isReservedPath(obj.Path) {
+			objects = append(objects, obj)
+		}
+	}
+	if len(objects) == 0 {
+		return nil, false, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	return objects, false, nil
+}
+
+// removeStaging removes a staging path together with its download state.
+func removeStaging(staging string) error {
+	for _, path := range []string{staging, staging + ".part", staging + ".json"} {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadResumable downloads src to dst through dst.part. When a previous run
+// left a partial file behind, the download continues after its last byte as
+// long as the backend supports ranged reads. size is the expected object size,
+// or -1 when it is unknown. Callers must discard stale partial files when the
+// remote object changed.
+func downloadResumable(ctx context.Context, backend Backend, opts BackendOptions, src string, dst string, size int64) error {
+	progress := opts.progress()
+	if _, err := os.Stat(dst); err == nil {
+		progress.FileSkipped()
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	part := dst + ".part"
+
+	reader, ok := backend.(RangeReader)
+	if !ok {
+		_ = os.Remove(part)
+		if err := pullFile(ctx, backend, opts, src, part); err != nil {
+			return err
+		}
+		return os.Rename(part, dst)
+	}
+
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err == nil && size >= 0 && offset > size {
+		offset, err = 0, file.Truncate(0)
+	}
+	progress.AddTotal(1, size)
+	progress.AddBytes(offset)
+	if err == nil && (size < 0 || offset < size) {
+		w := progressWriter{w: file, reporter: progress}
+		err = reader.ReadRange(ctx, src, offset, w)
+		if errors.Is(err, errRangeUnsupported) {
+			if _, err = file.Seek(0, io.SeekStart); err == nil {
+				if err = file.Truncate(0); err == nil {
+					progress.AddBytes(-offset)
+					err = reader.ReadRange(ctx, src, 0, w)
+				}
+			}
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(part, dst); err != nil {
+		return err
+	}
+	progress.FileDone()
+	return nil
+}
+
+// copyRangeResponse writes the body of a ranged GET to w. A server that
+// answers a range request with the full object yields errRangeUnsupported
+// before anything is written.
+func copyRangeResponse(resp *http.Response, offset int64, w io.Writer) error {
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return errRangeUnsupported
+	}
+	_, err := io.Copy(w, resp.Body)
+	return err
+}
+
+func rangeHeader(offset int64) map[string]string {
+	if offset <= 0 {
+		return nil
+	}
+	return map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}
+}
+
+// uploadCheckpoint records the finished parts of a multipart upload in the
+// user cache directory, so running the same push again skips them.
+type uploadCheckpoint struct {
+	Source   string         `json:"source"`
+	Dest     string         `json:"dest"`
+	Size     int64          `json:"size"`
+	ModTime  int64          `json:"mod_time"`
+	UploadID string         `json:"upload_id"`
+	Parts    map[int]string `json:"parts"`
+	path     string
+}
+
+// loadUploadCheckpoint returns the checkpoint of an earlier attempt to upload
+// src to dst, or a fresh one when there is none or src changed since.
+func loadUploadCheckpoint(src string, dst string, info os.FileInfo) uploadCheckpoint {
+	fresh := uploadCheckpoint{
+		Source:  src,
+		Dest:    dst,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Parts:   map[int]string{},
+	}
+	fresh.path = uploadCheckpointPath(src, dst)
+	if fresh.path == "" {
+		return fresh
+	}
+	content, err := os.ReadFile(fresh.path)
+	if err != nil {
+		return fresh
+	}
+	var cp uploadCheckpoint
+	if json.Unmarshal(content, &cp) != nil || cp.Size != fresh.Size || cp.ModTime != fresh.ModTime || cp.UploadID == "" {
+		return fresh
+	}
+	if cp.Parts == nil {
+		cp.Parts = map[int]string{}
+	}
+	cp.path = fresh.path
+	return cp
+}
+
+// uploadCheckpointPath returns where the checkpoint for uploading src to dst
+// is kept, or an empty string when there is no user cache directory.
+func uploadCheckpointPath(src string, dst string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(src + "\n" + dst))
+	return filepath.Join(cacheDir, "donk", "uploads", hex.EncodeToString(hash[:16])+".json")
+}
+
+func (cp uploadCheckpoint) partCount() int {
+	return int((cp.Size + multipartPartSize - 1) / multipartPartSize)
+}
+
+// readPart returns the content of a 1-based part of the source file.
+func (cp uploadCheckpoint) readPart(number int) ([]byte, error) {
+	file, err := os.Open(cp.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	offset := int64(number-1) * multipartPartSize
+	size := cp.Size - offset
+	if size > multipartPartSize {
+		size = multipartPartSize
+	}
+	buf := make([]byte, size)
+	if _, err := file.ReadAt(buf, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (cp uploadCheckpoint) save() error {
+	if cp.path == "" {
+		return nil
+	}
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cp.path), 0o755); err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp uploadCheckpoint) remove() {
+	if cp.path != "" {
+		_ = os.Remove(cp.path)
+	}
+}
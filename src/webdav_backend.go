@@ -0,0 +1,418 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webdavBackendProvider = "webdav"
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getetag/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+type WebDAVBackend struct {
+	cfg     OSSConfig
+	client  *http.Client
+	opts    BackendOptions
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				ETag          string `xml:"getetag"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+type webdavEntry struct {
+	path         string
+	isCollection bool
+	info         ObjectInfo
+}
+
+func init() {
+	factory := func(cfg OSSConfig, opts BackendOptions) (Backend, error) {
+		return NewWebDAVBackend(cfg, opts)
+	}
+	RegisterBackend("webdavs", webdavBackendProvider, factory)
+	RegisterBackend("webdav", "", factory)
+}
+
+func NewWebDAVBackend(cfg OSSConfig, opts BackendOptions) (*WebDAVBackend, error) {
+	if cfg.Token != "" && (cfg.Username != "" || cfg.Password != "") {
+		return nil, errors.New("failed to initialize WebDAV client because basic auth and bearer token cannot be used together")
+	}
+	return &WebDAVBackend{
+		cfg:     cfg,
+		client:  http.DefaultClient,
+		opts:    opts,
+		created: map[string]bool{},
+	}, nil
+}
+
+func (w *WebDAVBackend) Pull(src string, dst string) error {
+	return pullTree(w, w.opts, src, dst)
+}
+
+func (w *WebDAVBackend) Push(src string, dst string) error {
+	return pushTree(w, w.opts, src, dst)
+}
+
+func (w *WebDAVBackend) ReadObject(src string) ([]byte, error) {
+	target, err := w.parseUri(src)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.do(w.opts.ctx(), http.MethodGet, target, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while opening WebDAV file %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("read failed while opening WebDAV file %s: %w", src, err)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read failed while reading content from WebDAV file %s: %w", src, err)
+	}
+	return content, nil
+}
+
+func (w *WebDAVBackend) WriteObject(dst string, content []byte) error {
+	if err := w.put(w.opts.ctx(), dst, bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		return fmt.Errorf("write failed while uploading WebDAV file %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (w *WebDAVBackend) WriteObjectIfMatch(dst string, content []byte, etag string) error {
+	headers := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		headers = map[string]string{"If-Match": `"` + etag + `"`}
+	}
+	if err := w.put(w.opts.ctx(), dst, bytes.NewReader(content), int64(len(content)), headers); err != nil {
+		return fmt.Errorf("write failed while uploading WebDAV file %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (w *WebDAVBackend) List(prefix string) ([]ObjectInfo, error) {
+	target, err := w.parseUri(prefix)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(target.Path, "/") + "/"
+
+	objects := make([]ObjectInfo, 0)
+	pending := []string{base}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+		dirTarget := *target
+		dirTarget.Path = dir
+		entries, err := w.propfind(&dirTarget, "1")
+		if err != nil {
+			if errors.Is(err, errRemoteNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("list failed while listing WebDAV collection %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if strings.TrimSuffix(entry.path, "/") == strings.TrimSuffix(dir, "/") {
+				continue
+			}
+			if !strings.HasPrefix(entry.path, base) {
+				continue
+			}
+			if entry.isCollection {
+				pending = append(pending, strings.TrimSuffix(entry.path, "/")+"/")
+				continue
+			}
+			entry.info.Path = strings.TrimPrefix(entry.path, base)
+			objects = append(objects, entry.info)
+		}
+	}
+	sortObjectInfos(objects)
+	return objects, nil
+}
+
+func (w *WebDAVBackend) Delete(path string) error {
+	target, err := w.parseUri(path)
+	if err != nil {
+		return err
+	}
+	resp, err := w.do(w.opts.ctx(), http.MethodDelete, target, nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete failed while removing WebDAV path %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return fmt.Errorf("delete failed while removing WebDAV path %s: %w", path, err)
+	}
+	return nil
+}
+
+func (w *WebDAVBackend) Stat(path string) (ObjectInfo, error) {
+	target, err := w.parseUri(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	entries, err := w.propfind(target, "0")
+	if err != nil {
+		if errors.Is(err, errRemoteNotFound) {
+			return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+		}
+		return ObjectInfo{}, fmt.Errorf("stat failed while reading WebDAV properties %s: %w", path, err)
+	}
+	if len(entries) == 0 || entries[0].isCollection {
+		return ObjectInfo{}, fmt.Errorf("%w. Source path: %s", errRemoteNotFound, path)
+	}
+	info := entries[0].info
+	info.Path = entries[0].path
+	return info, nil
+}
+
+func (w *WebDAVBackend) downloadFile(ctx context.Context, src string, dst string) error {
+	target, err := w.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := w.do(ctx, http.MethodGet, target, nil, nil)
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading WebDAV file %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading WebDAV file %s: %w", src, err)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("pull failed while downloading WebDAV file %s: %w", src, err)
+	}
+	return file.Close()
+}
+
+func (w *WebDAVBackend) ReadRange(ctx context.Context, src string, offset int64, out io.Writer) error {
+	target, err := w.parseUri(src)
+	if err != nil {
+		return err
+	}
+	resp, err := w.do(ctx, http.MethodGet, target, nil, rangeHeader(offset))
+	if err != nil {
+		return fmt.Errorf("pull failed while downloading WebDAV file %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w. Source path: %s", errRemoteNotFound, src)
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return fmt.Errorf("pull failed while downloading WebDAV file %s: %w", src, err)
+	}
+	return copyRangeResponse(resp, offset, out)
+}
+
+func (w *WebDAVBackend) uploadFile(ctx context.Context, src string, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := w.put(ctx, dst, file, stat.Size(), nil); err != nil {
+		return fmt.Errorf("push failed while uploading WebDAV file %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (w *WebDAVBackend) put(ctx context.Context, dst string, body io.Reader, size int64, headers map[string]string) error {
+	target, err := w.parseUri(dst)
+	if err != nil {
+		return err
+	}
+	if err := w.ensureCollection(ctx, target, path.Dir(target.Path)); err != nil {
+		return err
+	}
+	requestHeaders := map[string]string{"Content-Length": strconv.FormatInt(size, 10)}
+	for key, value := range headers {
+		requestHeaders[key] = value
+	}
+	resp, err := w.do(ctx, http.MethodPut, target, body, requestHeaders)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errPreconditionFailed
+	}
+	return w.checkResponse(resp)
+}
+
+// ensureCollection creates the collection and its missing parents with MKCOL.
+func (w *WebDAVBackend) ensureCollection(ctx context.Context, target *url.URL, dir string) error {
+	if dir == "/" || dir == "." || w.isCreated(dir) {
+		return nil
+	}
+	if err := w.ensureCollection(ctx, target, path.Dir(dir)); err != nil {
+		return err
+	}
+	dirTarget := *target
+	dirTarget.Path = dir + "/"
+	resp, err := w.do(ctx, "MKCOL", &dirTarget, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 405 means the collection already exists.
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		if err := w.checkResponse(resp); err != nil {
+			return fmt.Errorf("failed to create WebDAV collection %s: %w", dir, err)
+		}
+	}
+	w.mu.Lock()
+	w.created[dir] = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *WebDAVBackend) isCreated(dir string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.created[dir]
+}
+
+func (w *WebDAVBackend) propfind(target *url.URL, depth string) ([]webdavEntry, error) {
+	resp, err := w.do(w.opts.ctx(), "PROPFIND", target, strings.NewReader(webdavPropfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errRemoteNotFound
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse WebDAV PROPFIND response: %w", err)
+	}
+	entries := make([]webdavEntry, 0, len(result.Responses))
+	for _, response := range result.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse WebDAV href %s: %w", response.Href, err)
+		}
+		entry := webdavEntry{path: href.Path}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			entry.isCollection = prop.ResourceType.Collection != nil
+			entry.info.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			entry.info.ETag = strings.Trim(strings.TrimPrefix(prop.ETag, "W/"), "\"")
+			entry.info.ModTime, _ = time.Parse(http.TimeFormat, prop.LastModified)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (w *WebDAVBackend) do(ctx context.Context, method string, target *url.URL, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if size, ok := headers["Content-Length"]; ok {
+		req.ContentLength, _ = strconv.ParseInt(size, 10, 64)
+	}
+	switch {
+	case w.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+w.cfg.Token)
+	case w.cfg.Username != "":
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+	return w.client.Do(req)
+}
+
+func (w *WebDAVBackend) checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("WebDAV request failed with status %s", resp.Status)
+}
+
+func (w *WebDAVBackend) parseUri(raw string) (*url.URL, error) {
+	scheme := uriScheme(raw)
+	if scheme != "webdav" && scheme != "webdavs" {
+		return nil, fmt.Errorf("invalid WebDAV path because the scheme is not webdav:// or webdavs://. Path: %s", raw)
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV path: %w", err)
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV path because the host segment is missing. Path: %s", raw)
+	}
+	target.Scheme = "https"
+	if scheme == "webdav" {
+		target.Scheme = "http"
+	}
+	target.Path = "/" + strings.Trim(target.Path, "/")
+	target.RawPath = ""
+	return target, nil
+}
@@ -0,0 +1,97 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVBackend(t *testing.T) {
+	server := newWebDAVTestServer()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	w, err := NewWebDAVBackend(OSSConfig{Name: "webdav", Username: "me", Password: "secret"}, BackendOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := "webdav://" + strings.TrimPrefix(httpServer.URL, "http://") + "/dav"
+
+	// Missing parents are created with MKCOL once, the handler refuses a PUT
+	// into a collection that does not exist.
+	if err := w.WriteObject(root+"/donk/cfg/nvim/init.lua", []byte("init")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteObject(root+"/donk/cfg/nvim/lua/plugins.lua", []byte("plugins")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteObject(root+"/donk/cfg/nvim/lua/keys.lua", []byte("keys")); err != nil {
+		t.Fatal(err)
+	}
+	if server.mkcol != 5 {
+		t.Fatalf("sent %d MKCOL requests, want 5", server.mkcol)
+	}
+	// Listing walks nested collections one PROPFIND at a time.
+	objects, err := w.List(root + "/donk/cfg/nvim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		paths = append(paths, obj.Path)
+	}
+	if got := strings.Join(paths, ","); got != "init.lua,lua/keys.lua,lua/plugins.lua" {
+		t.Fatalf("List() = %s, want init.lua,lua/keys.lua,lua/plugins.lua", got)
+	}
+
+	testBackendContract(t, w, root)
+
+	if server.unauthorized > 0 {
+		t.Fatalf("%d requests were sent without credentials", server.unauthorized)
+	}
+}
+
+// webdavTestServer serves an in-memory file system under /dav. The handler of
+// x/net/webdav ignores If-Match and If-None-Match on PUT, so the server checks
+// them against the ETag the handler reports for the current file.
+type webdavTestServer struct {
+	mu           sync.Mutex
+	handler      *webdav.Handler
+	mkcol        int
+	unauthorized int
+}
+
+func newWebDAVTestServer() *webdavTestServer {
+	return &webdavTestServer{handler: &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}}
+}
+
+func (s *webdavTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, password, ok := r.BasicAuth(); !ok || user != "me" || password != "secret" {
+		s.unauthorized++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "MKCOL" {
+		s.mkcol++
+	}
+	if r.Method == http.MethodPut {
+		head := httptest.NewRecorder()
+		s.handler.ServeHTTP(head, httptest.NewRequest(http.MethodHead, r.URL.EscapedPath(), nil))
+		etag, exists := head.Header().Get("ETag"), head.Code == http.StatusOK
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != etag)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	}
+	s.handler.ServeHTTP(w, r)
+}